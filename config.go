@@ -6,6 +6,20 @@ import (
 	yaml "gopkg.in/yaml.v2"
 )
 
+// Config struct for yaml
+type Config struct {
+	Label       `yaml:",inline"`
+	Groups      []string  `yaml:"groups"`
+	LogFormat   LogFormat `yaml:"format"`
+	Percentiles []float64 `yaml:"percentiles"`
+}
+
+// LogFormat struct for yaml
+type LogFormat struct {
+	Type    string `yaml:"type"`
+	Pattern string `yaml:"pattern"`
+}
+
 // Label struct for yaml
 type Label struct {
 	ApptimeLabel string `yaml:"apptime_label"`
@@ -15,9 +29,10 @@ type Label struct {
 	MethodLabel  string `yaml:"method_label"`
 	URILabel     string `yaml:"uri_label"`
 	TimeLabel    string `yaml:"time_label"`
+	HostLabel    string `yaml:"host_label"`
 }
 
-func loadYAML(filename string) (conf Label, err error) {
+func loadYAML(filename string) (conf Config, err error) {
 	buf, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return conf, err
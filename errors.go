@@ -14,6 +14,12 @@ func (skipErr) Error() string { return "skip" }
 
 func makeSkipError() error { return skipErr{} }
 
+type filterErr struct{}
+
+func (filterErr) Error() string { return "filtered" }
+
+func makeFilterError() error { return filterErr{} }
+
 // UnwrapErrors get important message from wrapped error message
 func UnwrapErrors(err error) (int, error) {
 	for e := err; e != nil; {
@@ -2,8 +2,8 @@ package poi
 
 func (p *Poi) arrowUpAction() {
 	if topPane {
-		if dataMap.start > 0 {
-			dataMap.start--
+		if d := p.topDict(); d.start > 0 {
+			d.start--
 		}
 		p.renderTopPane()
 	} else {
@@ -18,8 +18,8 @@ func (p *Poi) arrowUpAction() {
 
 func (p *Poi) arrowDownAction() {
 	if topPane {
-		if dataMap.start+dataMap.rownum < len(dataMap.keys) {
-			dataMap.start++
+		if d := p.topDict(); d.start+d.rownum < len(d.keys) {
+			d.start++
 		}
 		p.renderTopPane()
 	} else {
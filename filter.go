@@ -0,0 +1,300 @@
+package poi
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Code-Hex/exit"
+	"github.com/pkg/errors"
+)
+
+// predicate reports whether the line of the label map should be profiled
+type predicate func(tmp map[string]string) bool
+
+// makeFilter parses --filter expression like
+//
+//	status >= 500 && method == "POST"
+//	uri matches "^/api/" || !(user_agent !~ "bot")
+//
+// Operators are ==, !=, <, <=, >, >=, =~ (matches), !~ and
+// they can be combined with && (and), || (or), ! (not) and parentheses.
+func (p *Poi) makeFilter() error {
+	if p.Filter == "" {
+		return nil
+	}
+	fp, err := parseFilter(p.Filter, p.Label.resolve)
+	if err != nil {
+		return exit.MakeDataErr(errors.Wrapf(err, "invalid filter %q", p.Filter))
+	}
+	p.filter = fp.pred
+	p.filterLabels = fp.labels
+	return nil
+}
+
+// parseFilter parses expr. Logical names of labels like "uri" or "host"
+// in expr are resolved to labels in access log by resolve.
+func parseFilter(expr string, resolve func(string) string) (*filterParser, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	fp := &filterParser{tokens: tokens, resolve: resolve}
+	pred, err := fp.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := fp.peek(); tok.kind != tokEOF {
+		return nil, errors.Errorf("unexpected %q", tok.val)
+	}
+	fp.pred = pred
+	return fp, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+}
+
+// Operators ordered by length to match the longest one
+var filterOps = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!"}
+
+func tokenize(expr string) ([]token, error) {
+	tokens := make([]token, 0)
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokLParen, "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokRParen, ")"})
+			i++
+		case c == '"' || c == '\'':
+			end := i + 1
+			for end < len(expr) && expr[end] != byte(c) {
+				if expr[end] == '\\' && c == '"' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, errors.New("unterminated string")
+			}
+			s := expr[i+1 : end]
+			if c == '"' {
+				var err error
+				if s, err = strconv.Unquote(expr[i : end+1]); err != nil {
+					return nil, errors.Wrapf(err, "invalid string %s", expr[i:end+1])
+				}
+			}
+			tokens = append(tokens, token{tokString, s})
+			i = end + 1
+		case c == '-' || c == '.' || unicode.IsDigit(c):
+			end := i + 1
+			for end < len(expr) && (expr[end] == '.' || unicode.IsDigit(rune(expr[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{tokNumber, expr[i:end]})
+			i = end
+		case c == '_' || unicode.IsLetter(c):
+			end := i + 1
+			for end < len(expr) && isIdentChar(rune(expr[end])) {
+				end++
+			}
+			tokens = append(tokens, token{tokIdent, expr[i:end]})
+			i = end
+		default:
+			op := ""
+			for _, o := range filterOps {
+				if strings.HasPrefix(expr[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, errors.Errorf("unexpected %q", c)
+			}
+			tokens = append(tokens, token{tokOp, op})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{tokEOF, "end of filter"}), nil
+}
+
+func isIdentChar(c rune) bool {
+	return c == '_' || c == '.' || c == '-' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+type filterParser struct {
+	tokens  []token
+	pos     int
+	resolve func(string) string
+
+	// Results of parsing
+	pred   predicate
+	labels []string // logical names used in the filter
+}
+
+func (fp *filterParser) peek() token { return fp.tokens[fp.pos] }
+
+func (fp *filterParser) next() token {
+	tok := fp.tokens[fp.pos]
+	if tok.kind != tokEOF {
+		fp.pos++
+	}
+	return tok
+}
+
+// isOp reports whether tok is the operator or the keyword of op
+func isOp(tok token, op, keyword string) bool {
+	return tok.kind == tokOp && tok.val == op || tok.kind == tokIdent && tok.val == keyword
+}
+
+func (fp *filterParser) parseOr() (predicate, error) {
+	left, err := fp.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for isOp(fp.peek(), "||", "or") {
+		fp.next()
+		right, err := fp.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(tmp map[string]string) bool { return l(tmp) || right(tmp) }
+	}
+	return left, nil
+}
+
+func (fp *filterParser) parseAnd() (predicate, error) {
+	left, err := fp.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for isOp(fp.peek(), "&&", "and") {
+		fp.next()
+		right, err := fp.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(tmp map[string]string) bool { return l(tmp) && right(tmp) }
+	}
+	return left, nil
+}
+
+func (fp *filterParser) parseNot() (predicate, error) {
+	if isOp(fp.peek(), "!", "not") {
+		fp.next()
+		pred, err := fp.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(tmp map[string]string) bool { return !pred(tmp) }, nil
+	}
+	return fp.parsePrimary()
+}
+
+func (fp *filterParser) parsePrimary() (predicate, error) {
+	tok := fp.next()
+	switch tok.kind {
+	case tokLParen:
+		pred, err := fp.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := fp.next(); tok.kind != tokRParen {
+			return nil, errors.Errorf("expected ) but got %q", tok.val)
+		}
+		return pred, nil
+	case tokIdent:
+		return fp.parseComparison(tok.val)
+	}
+	return nil, errors.Errorf("expected a label but got %q", tok.val)
+}
+
+func (fp *filterParser) parseComparison(name string) (predicate, error) {
+	fp.labels = append(fp.labels, name)
+	label := fp.resolve(name)
+	op := fp.next()
+	if op.kind == tokIdent && op.val == "matches" {
+		op = token{tokOp, "=~"}
+	}
+	if op.kind != tokOp {
+		return nil, errors.Errorf("expected an operator after %s but got %q", label, op.val)
+	}
+	val := fp.next()
+	if val.kind != tokString && val.kind != tokNumber && val.kind != tokIdent {
+		return nil, errors.Errorf("expected a value after %s %s but got %q", label, op.val, val.val)
+	}
+
+	switch op.val {
+	case "=~", "!~":
+		re, err := regexp.Compile(val.val)
+		if err != nil {
+			return nil, err
+		}
+		want := op.val == "=~"
+		return func(tmp map[string]string) bool {
+			return re.MatchString(tmp[label]) == want
+		}, nil
+	case "==", "!=":
+		want := op.val == "=="
+		n, err := strconv.ParseFloat(val.val, 64)
+		if val.kind != tokNumber || err != nil {
+			return func(tmp map[string]string) bool {
+				return (tmp[label] == val.val) == want
+			}, nil
+		}
+		return func(tmp map[string]string) bool {
+			v, err := strconv.ParseFloat(tmp[label], 64)
+			if err != nil {
+				return (tmp[label] == val.val) == want
+			}
+			return (v == n) == want
+		}, nil
+	case "<", "<=", ">", ">=":
+		n, err := strconv.ParseFloat(val.val, 64)
+		if err != nil {
+			return nil, errors.Errorf("%s %s needs a number but got %q", label, op.val, val.val)
+		}
+		cmp := compareFunc(op.val)
+		return func(tmp map[string]string) bool {
+			v, err := strconv.ParseFloat(tmp[label], 64)
+			return err == nil && cmp(v, n)
+		}, nil
+	}
+	return nil, errors.Errorf("unexpected operator %q", op.val)
+}
+
+func compareFunc(op string) func(a, b float64) bool {
+	switch op {
+	case "<":
+		return func(a, b float64) bool { return a < b }
+	case "<=":
+		return func(a, b float64) bool { return a <= b }
+	case ">":
+		return func(a, b float64) bool { return a > b }
+	case ">=":
+		return func(a, b float64) bool { return a >= b }
+	}
+	panic(fmt.Sprintf("unknown operator %s", op))
+}
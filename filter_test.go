@@ -0,0 +1,144 @@
+package poi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	line := map[string]string{
+		"method":     "POST",
+		"uri":        "/api/users?page=2",
+		"status":     "503",
+		"apptime":    "0.250",
+		"user_agent": "Mozilla/5.0 (compatible; Googlebot/2.1)",
+		"user":       "-",
+		"referer":    "http://example.com/a b",
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		// Examples of the request
+		{`status >= 500`, true},
+		{`method == "POST"`, true},
+		{`uri matches "^/api/"`, true},
+		{`user_agent !~ "bot"`, false},
+
+		{`status < 500`, false},
+		{`status <= 503`, true},
+		{`status > 503`, false},
+		{`apptime > 0.1`, true},
+		{`apptime >= .3`, false},
+		{`method != "POST"`, false},
+		{`uri =~ "^/web/"`, false},
+		{`user_agent =~ "(?i)googlebot"`, true},
+
+		// Numeric or string equality
+		{`status == 503`, true},
+		{`status == 503.0`, true},
+		{`apptime == 0.25`, true},
+		{`apptime == "0.25"`, false},
+		{`status == "503"`, true},
+		{`user == -`, true},
+		{`user == "-"`, true},
+		{`method == POST`, true},
+		{`missing == ""`, true},
+		{`missing > 0`, false},
+
+		// Quoting
+		{`referer == "http://example.com/a b"`, true},
+		{`referer == 'http://example.com/a b'`, true},
+		{`user_agent == "Mozilla/5.0 (compatible; Googlebot/2.1)"`, true},
+		{`uri =~ '\?page='`, true},
+		{`uri =~ "\\?page="`, true},
+		{`method == "P\x4fST"`, true},
+
+		// Logical operators and precedence
+		{`status >= 500 && method == "POST"`, true},
+		{`status >= 500 and method == "GET"`, false},
+		{`status < 500 || method == "POST"`, true},
+		{`status < 500 or method == "GET"`, false},
+		{`!(status < 500)`, true},
+		{`not status < 500`, true},
+		{`!!(status < 500)`, false},
+		{`not not status >= 500`, true},
+		// && binds tighter than ||
+		{`method == "GET" && status < 500 || uri matches "^/api/"`, true},
+		{`uri matches "^/api/" || method == "GET" && status < 500`, true},
+		{`method == "GET" && (status < 500 || uri matches "^/api/")`, false},
+		{`(uri matches "^/api/" || method == "GET") && status < 500`, false},
+		// ! binds tighter than &&
+		{`!method == "GET" && status >= 500`, true},
+		{`!(method == "POST" && status >= 500)`, false},
+		{`((status >= 500))`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			fp, err := parseFilter(tt.expr, func(name string) string { return name })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fp.pred(line); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterLabels(t *testing.T) {
+	labels := map[string]string{"uri": "path", "host": "vhost"}
+	resolve := func(name string) string {
+		if l, ok := labels[name]; ok {
+			return l
+		}
+		return name
+	}
+	fp, err := parseFilter(`uri matches "^/api/" && (host == "a" || status >= 500)`, resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"uri", "host", "status"}; !reflect.DeepEqual(fp.labels, want) {
+		t.Errorf("labels got %v, want %v", fp.labels, want)
+	}
+	if !fp.pred(map[string]string{"path": "/api/", "vhost": "a"}) {
+		t.Error("resolved labels are not used")
+	}
+	if fp.pred(map[string]string{"uri": "/api/", "host": "a"}) {
+		t.Error("logical names are used instead of the labels")
+	}
+}
+
+func TestParseFilterError(t *testing.T) {
+	tests := []string{
+		``,
+		`status`,
+		`status >=`,
+		`>= 500`,
+		`status >= 500 &&`,
+		`|| status >= 500`,
+		`!`,
+		`(status >= 500`,
+		`status >= 500)`,
+		`((status >= 500)`,
+		`()`,
+		`status >= "abc"`,
+		`status 500`,
+		`status >= 500 method == "GET"`,
+		`uri matches "("`,
+		`uri =~ "[a-"`,
+		`method == "POST`,
+		`method == 'POST`,
+		`method == "\q"`,
+		`status # 500`,
+		`status = 500`,
+		`status & 500`,
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := parseFilter(expr, func(name string) string { return name }); err == nil {
+				t.Error("want error, but got nil")
+			}
+		})
+	}
+}
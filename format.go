@@ -0,0 +1,215 @@
+package poi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Code-Hex/exit"
+	"github.com/pkg/errors"
+)
+
+// lineParser parses a line of access log into the label map.
+// It returns skip error if the line could not be parsed.
+type lineParser func(text string) (map[string]string, error)
+
+var (
+	// %h %l %u %t "%r" %>s %b
+	commonRe = regexp.MustCompile(`^(\S+) (\S+) (\S+) \[([^\]]+)\] "([^"]*)" (\d{3}) (\d+|-)(.*)$`)
+	// %h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"
+	combinedRe = regexp.MustCompile(`^(\S+) (\S+) (\S+) \[([^\]]+)\] "([^"]*)" (\d{3}) (\d+|-) "([^"]*)" "([^"]*)"(.*)$`)
+)
+
+func (p *Poi) makeParser() error {
+	// Command line options have priority over yaml
+	if p.Format == "" {
+		p.Format = p.LogFormat.Type
+	}
+	if p.Format == "" {
+		p.Format = "ltsv" // default
+	}
+	if p.Pattern == "" {
+		p.Pattern = p.LogFormat.Pattern
+	}
+
+	switch p.Format {
+	case "ltsv":
+		p.parse = func(text string) (map[string]string, error) {
+			return parseLTSV(text), nil
+		}
+	case "common", "common-D", "common-T", "combined", "combined-D", "combined-T":
+		name, unit := p.Format, ""
+		if i := strings.IndexByte(name, '-'); i >= 0 {
+			name, unit = name[:i], name[i+1:]
+		}
+		p.parse = makeNCSAParser(name == "combined", unit)
+		// Plain formats have no response time
+		p.noResTime = unit == ""
+	case "jsonl":
+		p.parse = parseJSONL
+	case "regexp":
+		parse, err := makeRegexpParser(p.Pattern)
+		if err != nil {
+			return exit.MakeDataErr(err)
+		}
+		p.parse = parse
+	default:
+		return exit.MakeDataErr(errors.Errorf("unknown format %q", p.Format))
+	}
+	return nil
+}
+
+// warnNoResTime writes a warning that response times are all zero,
+// because the format has no response time
+func (p *Poi) warnNoResTime(w io.Writer) {
+	fmt.Fprintf(w, "Warning: response times are 0 because --format %s has no response time, use %s-D or %s-T for %%D or %%T at the end\n", p.Format, p.Format, p.Format)
+}
+
+// makeNCSAParser makes the parser of the common or combined log format.
+// unit is of the response time next to them, "D" for %D (microseconds),
+// "T" for %T or nginx's $request_time (seconds) or "" for none.
+func makeNCSAParser(combined bool, unit string) lineParser {
+	return func(text string) (map[string]string, error) {
+		var (
+			tmp  map[string]string
+			rest string
+			err  error
+		)
+		if combined {
+			m := combinedRe.FindStringSubmatch(text)
+			if m == nil {
+				return nil, skip
+			}
+			if tmp, err = makeNCSAMap(m[1:8]); err != nil {
+				return nil, err
+			}
+			tmp["referer"] = m[8]
+			tmp["user_agent"] = m[9]
+			rest = m[10]
+		} else {
+			m := commonRe.FindStringSubmatch(text)
+			if m == nil {
+				return nil, skip
+			}
+			if tmp, err = makeNCSAMap(m[1:8]); err != nil {
+				return nil, err
+			}
+			rest = m[8]
+		}
+
+		// The response time is the first field of the rest. Lines without
+		// it have no "request_time" label, they are ignored by parseLabel.
+		fields := strings.Fields(rest)
+		if unit == "" || len(fields) == 0 {
+			return tmp, nil
+		}
+		switch unit {
+		case "D":
+			if usec, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+				tmp["request_time"] = strconv.FormatFloat(float64(usec)/1e6, 'f', -1, 64)
+			}
+		case "T":
+			if _, err := strconv.ParseFloat(fields[0], 64); err == nil {
+				tmp["request_time"] = fields[0]
+			}
+		}
+		return tmp, nil
+	}
+}
+
+// makeNCSAMap makes the label map from fields of the common log format
+func makeNCSAMap(fields []string) (map[string]string, error) {
+	request := strings.Fields(fields[4])
+	if len(request) != 3 {
+		return nil, skip // e.g. "-" or broken request line
+	}
+	size := fields[6]
+	if size == "-" {
+		size = "0"
+	}
+	tmp := map[string]string{
+		"remote_addr": fields[0],
+		"ident":       fields[1],
+		"user":        fields[2],
+		"time":        fields[3],
+		"method":      request[0],
+		"uri":         request[1],
+		"protocol":    request[2],
+		"status":      fields[5],
+		"size":        size,
+	}
+	return tmp, nil
+}
+
+// makeRegexpParser makes the parser which uses named groups of pattern as labels
+func makeRegexpParser(pattern string) (lineParser, error) {
+	if pattern == "" {
+		return nil, errors.New("--pattern is required for regexp format")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid pattern %q", pattern)
+	}
+	names := re.SubexpNames()
+	named := false
+	for _, name := range names {
+		if name != "" {
+			named = true
+			break
+		}
+	}
+	if !named {
+		return nil, errors.Errorf("pattern %q has no named groups like (?P<uri>...)", pattern)
+	}
+	return func(text string) (map[string]string, error) {
+		m := re.FindStringSubmatch(text)
+		if m == nil {
+			return nil, skip
+		}
+		tmp := make(map[string]string, len(names))
+		for i, name := range names {
+			if name != "" {
+				tmp[name] = m[i]
+			}
+		}
+		return tmp, nil
+	}, nil
+}
+
+// parseJSONL parses a line of JSON Lines. Nested fields are flattened
+// with dotted keys like "request.uri", array elements like "headers.0".
+func parseJSONL(text string) (map[string]string, error) {
+	var v map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber() // To keep numbers as they are written
+	if err := dec.Decode(&v); err != nil {
+		return nil, skip
+	}
+	tmp := make(map[string]string, len(v))
+	flatten(tmp, "", v)
+	return tmp, nil
+}
+
+func flatten(tmp map[string]string, prefix string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, val := range v {
+			flatten(tmp, prefix+key+".", val)
+		}
+	case []interface{}:
+		for i, val := range v {
+			flatten(tmp, prefix+strconv.Itoa(i)+".", val)
+		}
+	case string:
+		tmp[strings.TrimSuffix(prefix, ".")] = v
+	case json.Number:
+		tmp[strings.TrimSuffix(prefix, ".")] = v.String()
+	case bool:
+		tmp[strings.TrimSuffix(prefix, ".")] = strconv.FormatBool(v)
+	case nil:
+		tmp[strings.TrimSuffix(prefix, ".")] = ""
+	}
+}
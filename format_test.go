@@ -0,0 +1,85 @@
+package poi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNCSAParser(t *testing.T) {
+	const (
+		common   = `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`
+		combined = common + ` "http://www.example.com/start.html" "Mozilla/4.08 [en] (Win98; I ;Nav)"`
+	)
+	base := func(extra map[string]string) map[string]string {
+		m := map[string]string{
+			"remote_addr": "127.0.0.1",
+			"ident":       "-",
+			"user":        "frank",
+			"time":        "10/Oct/2000:13:55:36 -0700",
+			"method":      "GET",
+			"uri":         "/apache_pb.gif",
+			"protocol":    "HTTP/1.0",
+			"status":      "200",
+			"size":        "2326",
+		}
+		for k, v := range extra {
+			m[k] = v
+		}
+		return m
+	}
+	ua := map[string]string{
+		"referer":    "http://www.example.com/start.html",
+		"user_agent": "Mozilla/4.08 [en] (Win98; I ;Nav)",
+	}
+	with := func(m map[string]string, k, v string) map[string]string {
+		c := make(map[string]string, len(m)+1)
+		for k, v := range m {
+			c[k] = v
+		}
+		c[k] = v
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		combined bool
+		unit     string
+		line     string
+		want     map[string]string
+		wantSkip bool
+	}{
+		{name: "common", line: common, want: base(nil)},
+		{name: "common with trailing number", line: common + " 2", want: base(nil)},
+		{name: "common %D", unit: "D", line: common + " 1234", want: base(map[string]string{"request_time": "0.001234"})},
+		{name: "common %T", unit: "T", line: common + " 2", want: base(map[string]string{"request_time": "2"})},
+		{name: "common %T with fraction", unit: "T", line: common + " 0.250", want: base(map[string]string{"request_time": "0.250"})},
+		{name: "common %D missing", unit: "D", line: common, want: base(nil)},
+		{name: "common %D not a number", unit: "D", line: common + " 0.250", want: base(nil)},
+		{name: "combined", combined: true, line: combined, want: base(ua)},
+		{name: "combined with trailing number", combined: true, line: combined + " 2", want: base(ua)},
+		{name: "combined %D", combined: true, unit: "D", line: combined + " 1234", want: with(base(ua), "request_time", "0.001234")},
+		{name: "combined %T", combined: true, unit: "T", line: combined + " 2", want: with(base(ua), "request_time", "2")},
+		{name: "combined %T missing", combined: true, unit: "T", line: combined, want: base(ua)},
+		{name: "size -", line: `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 -`, want: base(map[string]string{"size": "0"})},
+		{name: "broken request", line: `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "-" 400 0`, wantSkip: true},
+		{name: "not ncsa", line: "method:GET\turi:/", wantSkip: true},
+		{name: "common for combined", combined: true, line: common, wantSkip: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := makeNCSAParser(tt.combined, tt.unit)(tt.line)
+			if tt.wantSkip {
+				if _, ok := err.(skipErr); !ok {
+					t.Fatalf("want skip error, but got %v, %v", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("\n got: %v\nwant: %v", got, tt.want)
+			}
+		})
+	}
+}
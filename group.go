@@ -0,0 +1,146 @@
+package poi
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/Code-Hex/exit"
+	"github.com/pkg/errors"
+)
+
+func (p *Poi) makeGroups() error {
+	patterns := p.MatchingGroups
+	if len(patterns) == 0 {
+		patterns = p.Groups // from yaml
+	}
+	p.groups = make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return exit.MakeDataErr(errors.Wrapf(err, "invalid matching group %q", pattern))
+		}
+		p.groups = append(p.groups, re)
+	}
+	return nil
+}
+
+// groupURI returns the first pattern which matches uri.
+// If nothing matched, returns uri as it is or normalized one with --auto-normalize.
+func (p *Poi) groupURI(uri string) string {
+	for _, re := range p.groups {
+		if re.MatchString(uri) {
+			return re.String()
+		}
+	}
+	if p.AutoNormalize {
+		return normalizeURI(uri)
+	}
+	return uri
+}
+
+var (
+	uuidRe  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hexRe   = regexp.MustCompile(`^[0-9a-fA-F]{16,}$`)
+	tokenRe = regexp.MustCompile(`^[0-9A-Za-z_\-+=]{20,}$`)
+)
+
+// normalizeURI replaces variable segments of uri with placeholders.
+//
+//	all-digit   -> :id
+//	uuid        -> :uuid
+//	long hex    -> :hex
+//	base64-ish  -> :token
+func normalizeURI(uri string) string {
+	segments := strings.Split(uri, "/")
+	for i, s := range segments {
+		if s == "" {
+			continue
+		}
+		switch {
+		case isDigits(s):
+			segments[i] = ":id"
+		case uuidRe.MatchString(s):
+			segments[i] = ":uuid"
+		case hexRe.MatchString(s):
+			segments[i] = ":hex"
+		case tokenRe.MatchString(s) && containsDigitAndLetter(s):
+			segments[i] = ":token"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || '9' < c {
+			return false
+		}
+	}
+	return true
+}
+
+func containsDigitAndLetter(s string) bool {
+	var digit, letter bool
+	for _, c := range s {
+		switch {
+		case '0' <= c && c <= '9':
+			digit = true
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+			letter = true
+		}
+	}
+	return digit && letter
+}
+
+// makeGroupBy parses --group-by
+func (p *Poi) makeGroupBy() error {
+	p.groupBy = p.groupBy[:0]
+	p.uriKeyIdx = -1
+	for _, name := range strings.Split(p.GroupBy, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return exit.MakeDataErr(errors.Errorf("invalid --group-by %q", p.GroupBy))
+		}
+		if name == "uri" {
+			p.uriKeyIdx = len(p.groupBy)
+		}
+		p.groupBy = append(p.groupBy, name)
+	}
+	return nil
+}
+
+// groupKeys returns values of --group-by labels.
+// "uri", "method" and "status" are the parsed values, others are taken from tmp
+// through the label yaml like "host".
+func (p *Poi) groupKeys(tmp map[string]string, uri, method, status string) []string {
+	keys := make([]string, len(p.groupBy))
+	for i, name := range p.groupBy {
+		switch name {
+		case "uri":
+			keys[i] = uri
+		case "method":
+			keys[i] = method
+		case "status":
+			keys[i] = status
+		default:
+			if v := tmp[p.Label.resolve(name)]; v != "" {
+				keys[i] = v
+			} else {
+				keys[i] = "-"
+			}
+		}
+	}
+	return keys
+}
+
+// isGrouping reports whether raw URIs are aggregated into a key
+func (p *Poi) isGrouping() bool {
+	return len(p.groups) > 0 || p.AutoNormalize
+}
+
+// countURIs returns number of distinct raw URIs aggregated into uri
+func (p *Poi) countURIs(uri string) int {
+	dataMap.mu.RLock()
+	defer dataMap.mu.RUnlock()
+	return len(dataMap.uris[uri])
+}
@@ -0,0 +1,104 @@
+package poi
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Code-Hex/exit"
+	"github.com/pkg/errors"
+)
+
+// headless writes a report every --interval instead of the terminal UI.
+// It writes the last report when all lines are aggregated or interrupted.
+func (p *Poi) headless(done <-chan struct{}) error {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.writeReport(); err != nil {
+				return err
+			}
+		case <-sig:
+			return p.writeReport()
+		case <-done:
+			return p.writeReport()
+		}
+	}
+}
+
+// writeReport renders the current results by --output, and resets
+// them with --reset
+func (p *Poi) writeReport() error {
+	var buf bytes.Buffer
+
+	dataMu.Lock()
+	dataMap.resetRangeInfo() // render all keys
+	err := p.render(&buf)
+	if err == nil && p.Reset {
+		p.resetData()
+	}
+	dataMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if p.ReportFile == "" {
+		if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+			return exit.MakeIOErr(errors.Wrap(err, "Failed to write report"))
+		}
+		return nil
+	}
+	if err := rotateFile(p.ReportFile, p.ReportKeep); err != nil {
+		return exit.MakeIOErr(errors.Wrap(err, "Failed to rotate report files"))
+	}
+	// Write to the temporary file to replace the report atomically
+	tmp := p.ReportFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return exit.MakeIOErr(errors.Wrap(err, "Failed to write report"))
+	}
+	if err := os.Rename(tmp, p.ReportFile); err != nil {
+		return exit.MakeIOErr(errors.Wrap(err, "Failed to write report"))
+	}
+	return nil
+}
+
+// rotateFile renames name to name.1, name.1 to name.2 and so on,
+// and removes the ones over keep
+func rotateFile(name string, keep int) error {
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		return nil
+	}
+	if keep <= 0 {
+		return os.Remove(name)
+	}
+	for i := keep - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", name, i)
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", name, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(name, name+".1")
+}
+
+// resetData clears the results and the counters of lines.
+// dataMu must be locked.
+func (p *Poi) resetData() {
+	dataMap = newDict()
+	mu.Lock()
+	p.row, p.ignored, p.filtered = 0, 0, 0
+	for _, f := range p.files {
+		f.read, f.ignored, f.filtered = 0, 0, 0
+	}
+	mu.Unlock()
+}
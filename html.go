@@ -0,0 +1,266 @@
+package poi
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Code-Hex/exit"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Maximum number of keys to draw latency histograms
+	htmlMaxHistograms = 50
+	// Number of ranges of a latency histogram
+	htmlHistogramBins = 20
+	// Maximum number of bars of requests over time
+	htmlTimelineBars = 120
+)
+
+type htmlReport struct {
+	Title          string
+	Generated      string
+	Summary        string
+	Header         []string
+	Numeric        []bool
+	Rows           [][]htmlCell
+	Timeline       *htmlChart
+	Histograms     []htmlHistogram
+	HistogramsOmit int
+}
+
+type htmlCell struct {
+	Text  string
+	Value string // value to sort by
+}
+
+type htmlChart struct {
+	Width, Height int
+	Bars          []htmlBar
+	From, To      string
+	Max           int
+}
+
+type htmlBar struct {
+	X, Y, W, H float64
+	Title      string
+}
+
+type htmlHistogram struct {
+	Key   string
+	Chart htmlChart
+}
+
+// renderHTML writes a self-contained html report which has the sortable
+// table, latency histograms of each key and requests over time
+func (p *Poi) renderHTML(w io.Writer) error {
+	keys := dataMap.sortedKeys(p.Sortby)
+
+	report := htmlReport{
+		Title:     p.Title,
+		Generated: time.Now().Format(time.RFC3339),
+		Header:    p.header,
+		Numeric:   make([]bool, len(p.header)),
+		Rows:      make([][]htmlCell, 0, len(keys)),
+	}
+	if report.Title == "" {
+		report.Title = name + " report"
+	}
+
+	mu.RLock()
+	report.Summary = fmt.Sprintf("Read lines: %d, Ignore lines: %d, Filtered lines: %d, Total URI: %d",
+		p.row, p.ignored, p.filtered, dataMap.totalURIs())
+	mu.RUnlock()
+
+	for i := range p.header {
+		report.Numeric[i] = i < p.keyIdx
+	}
+	for _, key := range keys {
+		val := dataMap.get(key)
+		texts, values := p.cells(val, false), p.cells(val, true)
+		row := make([]htmlCell, len(texts))
+		for i := range texts {
+			row[i] = htmlCell{Text: texts[i], Value: values[i]}
+		}
+		report.Rows = append(report.Rows, row)
+	}
+
+	report.Timeline = makeTimeline(dataMap.timeline)
+	report.Histograms = makeHistograms(keys)
+	if len(keys) > htmlMaxHistograms {
+		report.HistogramsOmit = len(keys) - htmlMaxHistograms
+	}
+
+	if err := htmlTemplate.Execute(w, report); err != nil {
+		return exit.MakeIOErr(errors.Wrap(err, "Failed to write html"))
+	}
+	return nil
+}
+
+// makeTimeline makes the bar chart of requests over time, or nil if
+// the time label is not found
+func makeTimeline(timeline map[int64]int) *htmlChart {
+	if len(timeline) == 0 {
+		return nil
+	}
+	first, last := int64(math.MaxInt64), int64(math.MinInt64)
+	for sec := range timeline {
+		if sec < first {
+			first = sec
+		}
+		if sec > last {
+			last = sec
+		}
+	}
+
+	// Seconds per bar
+	span := last - first + 1
+	step := (span + htmlTimelineBars - 1) / htmlTimelineBars
+	counts := make([]int, (span+step-1)/step)
+	for sec, n := range timeline {
+		counts[(sec-first)/step] += n
+	}
+
+	chart := makeBarChart(counts, 800, 160, func(i int) string {
+		from := time.Unix(first+int64(i)*step, 0).Format(time.RFC3339)
+		return fmt.Sprintf("%s (%ds): %d requests", from, step, counts[i])
+	})
+	chart.From = time.Unix(first, 0).Format(time.RFC3339)
+	chart.To = time.Unix(last, 0).Format(time.RFC3339)
+	return &chart
+}
+
+// makeHistograms makes latency histograms of keys in the same
+// log-scaled ranges, so that they can be compared with each other
+func makeHistograms(keys []string) []htmlHistogram {
+	if len(keys) > htmlMaxHistograms {
+		keys = keys[:htmlMaxHistograms]
+	}
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, key := range keys {
+		val := dataMap.get(key)
+		lo = math.Min(lo, val.minTime)
+		hi = math.Max(hi, val.maxTime)
+	}
+	lo = math.Max(lo, 1e-3) // 1ms
+	if hi <= lo {
+		hi = lo * 10
+	}
+	edges := make([]float64, htmlHistogramBins+1)
+	for i := range edges {
+		edges[i] = lo * math.Pow(hi/lo, float64(i)/htmlHistogramBins)
+	}
+
+	histograms := make([]htmlHistogram, 0, len(keys))
+	for _, key := range keys {
+		val := dataMap.get(key)
+		counts := val.times.binCounts(edges)
+		chart := makeBarChart(counts, 300, 80, func(i int) string {
+			return fmt.Sprintf("%.3f-%.3f: %d requests", edges[i], edges[i+1], counts[i])
+		})
+		chart.From = fmt.Sprintf("%.3f", edges[0])
+		chart.To = fmt.Sprintf("%.3f", edges[len(edges)-1])
+		histograms = append(histograms, htmlHistogram{
+			Key:   strings.Join(val.keys, " "),
+			Chart: chart,
+		})
+	}
+	return histograms
+}
+
+func makeBarChart(counts []int, width, height int, title func(i int) string) htmlChart {
+	chart := htmlChart{Width: width, Height: height}
+	for _, n := range counts {
+		if n > chart.Max {
+			chart.Max = n
+		}
+	}
+	w := float64(width) / float64(len(counts))
+	for i, n := range counts {
+		h := 0.0
+		if chart.Max > 0 {
+			h = float64(height) * float64(n) / float64(chart.Max)
+		}
+		chart.Bars = append(chart.Bars, htmlBar{
+			X:     float64(i) * w,
+			Y:     float64(height) - h,
+			W:     math.Max(w-1, 1),
+			H:     h,
+			Title: title(i),
+		})
+	}
+	return chart
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Helvetica Neue", Arial, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; font-size: 13px; }
+th, td { border: 1px solid #ccc; padding: 3px 8px; white-space: nowrap; }
+th { background: #f3f3f3; cursor: pointer; user-select: none; }
+th.asc::after { content: " \25B2"; }
+th.desc::after { content: " \25BC"; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+rect { fill: #4a90d9; }
+rect:hover { fill: #e67e22; }
+figure { display: inline-block; margin: 0 1em 1em 0; }
+figcaption { font-size: 12px; max-width: 300px; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+.axis { display: flex; justify-content: space-between; font-size: 11px; color: #666; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Summary}}<br><small>Generated at {{.Generated}}</small></p>
+
+{{define "chart"}}<svg width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+{{- range .Bars}}<rect x="{{printf "%.1f" .X}}" y="{{printf "%.1f" .Y}}" width="{{printf "%.1f" .W}}" height="{{printf "%.1f" .H}}"><title>{{.Title}}</title></rect>{{end -}}
+</svg>
+<div class="axis" style="width: {{.Width}}px"><span>{{.From}}</span><span>max {{.Max}}</span><span>{{.To}}</span></div>{{end}}
+
+<h2>Requests over time</h2>
+{{if .Timeline}}{{template "chart" .Timeline}}{{else}}<p>The time label was not found.</p>{{end}}
+
+<h2>Summary</h2>
+<table id="summary">
+<thead><tr>{{range $i, $h := .Header}}<th data-num="{{index $.Numeric $i}}">{{$h}}</th>{{end}}</tr></thead>
+<tbody>
+{{- range .Rows}}
+<tr>{{range $i, $c := .}}<td{{if index $.Numeric $i}} class="num"{{end}} data-v="{{$c.Value}}">{{$c.Text}}</td>{{end}}</tr>
+{{- end}}
+</tbody>
+</table>
+
+<h2>Latency histograms</h2>
+{{range .Histograms}}<figure><figcaption title="{{.Key}}">{{.Key}}</figcaption>{{template "chart" .Chart}}</figure>
+{{end}}
+{{- if .HistogramsOmit}}<p>{{.HistogramsOmit}} more keys are omitted.</p>{{end}}
+
+<script>
+document.querySelectorAll("#summary th").forEach(function(th, col) {
+  th.addEventListener("click", function() {
+    var desc = !th.classList.contains("desc");
+    document.querySelectorAll("#summary th").forEach(function(h) { h.classList.remove("asc", "desc"); });
+    th.classList.add(desc ? "desc" : "asc");
+    var num = th.dataset.num === "true";
+    var tbody = document.querySelector("#summary tbody");
+    var rows = Array.prototype.slice.call(tbody.rows);
+    rows.sort(function(a, b) {
+      var x = a.cells[col].dataset.v, y = b.cells[col].dataset.v;
+      var c = num ? parseFloat(x) - parseFloat(y) : x.localeCompare(y);
+      return desc ? -c : c;
+    });
+    rows.forEach(function(r) { tbody.appendChild(r); });
+  });
+});
+</script>
+</body>
+</html>
+`))
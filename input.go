@@ -0,0 +1,288 @@
+package poi
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Code-Hex/exit"
+	"github.com/hpcloud/tail"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// stdin is the filename to read access log from stdin
+const stdin = "-"
+
+// logFile holds the name of access log and lines counted in it
+type logFile struct {
+	name string
+	lineCount
+}
+
+type lineCount struct {
+	read, ignored, filtered int
+}
+
+// lineCounts counts lines of each file in a goroutine, to be added by
+// addLines at once instead of locking per line
+type lineCounts map[*logFile]*lineCount
+
+func (c lineCounts) of(f *logFile) *lineCount {
+	n, ok := c[f]
+	if !ok {
+		n = &lineCount{}
+		c[f] = n
+	}
+	return n
+}
+
+// makeFiles expands shell-style globs of --file
+func (p *Poi) makeFiles() error {
+	p.files = make([]*logFile, 0, len(p.Filenames))
+	for _, name := range p.Filenames {
+		if name == stdin {
+			p.files = append(p.files, &logFile{name: name})
+			continue
+		}
+		matches, err := filepath.Glob(name)
+		if err != nil {
+			return exit.MakeDataErr(errors.Wrapf(err, "invalid file pattern %q", name))
+		}
+		if len(matches) == 0 {
+			// Keep it to report an error when open it
+			matches = []string{name}
+		}
+		for _, m := range matches {
+			p.files = append(p.files, &logFile{name: m})
+		}
+	}
+	return nil
+}
+
+// Magic bytes of compressed file
+var magics = []struct {
+	name  string
+	magic []byte
+}{
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"bzip2", []byte("BZh")},
+	{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// detectCompression returns the name of compression, or "" for plain text
+func detectCompression(br *bufio.Reader) string {
+	for _, m := range magics {
+		if b, err := br.Peek(len(m.magic)); err == nil && bytes.Equal(b, m.magic) {
+			return m.name
+		}
+	}
+	return ""
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r *readCloser) Close() error { return r.close() }
+
+// openFile opens the file of access log. If it is compressed, decompress while reading.
+func openFile(name string) (io.ReadCloser, error) {
+	f := os.Stdin
+	if name != stdin {
+		var err error
+		f, err = os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	br := bufio.NewReader(f)
+	r := &readCloser{Reader: br, close: f.Close}
+	switch detectCompression(br) {
+	case "gzip":
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "failed to decompress %s", name)
+		}
+		r.Reader = zr
+	case "bzip2":
+		r.Reader = bzip2.NewReader(br)
+	case "zstd":
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "failed to decompress %s", name)
+		}
+		r.Reader = zr
+		r.close = func() error {
+			zr.Close()
+			return f.Close()
+		}
+	}
+	return r, nil
+}
+
+// lineReader reads lines one by one without loading whole of the file on memory.
+type lineReader struct {
+	br     *bufio.Reader
+	maxLen int
+}
+
+func newLineReader(r io.Reader, maxLen int) *lineReader {
+	// The buffer has room for the end of line
+	return &lineReader{br: bufio.NewReaderSize(r, maxLen+2), maxLen: maxLen}
+}
+
+// readLine returns a line without the end of line.
+// If the line is longer than maxLen, discards it and returns skip error.
+func (lr *lineReader) readLine() (string, error) {
+	line, err := lr.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		// Discard the rest of the line
+		for err == bufio.ErrBufferFull {
+			_, err = lr.br.ReadSlice('\n')
+		}
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		return "", skip
+	}
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return "", err
+	}
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if len(line) > lr.maxLen {
+		return "", skip
+	}
+	return string(line), nil
+}
+
+// checkCompression returns an error if br is compressed, because it could not be tailed
+func checkCompression(name string, br *bufio.Reader) error {
+	if c := detectCompression(br); c != "" {
+		return exit.MakeDataErr(errors.Errorf("%s is compressed by %s, could not tail it", name, c))
+	}
+	return nil
+}
+
+// readLine counts a line read from f
+func (p *Poi) readLine(f *logFile) {
+	mu.Lock()
+	p.row++
+	f.read++
+	mu.Unlock()
+}
+
+// ignoreLine counts a line which could not be parsed
+func (p *Poi) ignoreLine(f *logFile) {
+	mu.Lock()
+	p.ignored++
+	f.ignored++
+	mu.Unlock()
+}
+
+// filterLine counts a line which is filtered out by --filter
+func (p *Poi) filterLine(f *logFile) {
+	mu.Lock()
+	p.filtered++
+	f.filtered++
+	mu.Unlock()
+}
+
+// addLines adds lines counted in c
+func (p *Poi) addLines(c lineCounts) {
+	mu.Lock()
+	for f, n := range c {
+		p.row += n.read
+		p.ignored += n.ignored
+		p.filtered += n.filtered
+		f.read += n.read
+		f.ignored += n.ignored
+		f.filtered += n.filtered
+	}
+	mu.Unlock()
+}
+
+// tailFile sends lines of f to sendCh until stop is called
+func (p *Poi) tailFile(f *logFile, sendCh chan<- lineData) (stop func(), wait func() error, err error) {
+	if f.name == stdin {
+		br := bufio.NewReader(os.Stdin)
+		lr := newLineReader(br, p.MaxLineLen)
+		stopped := make(chan struct{})
+		stop = func() {
+			close(stopped)
+			os.Stdin.Close() // To interrupt blocking read
+		}
+		wait = func() error {
+			if err := checkCompression("stdin", br); err != nil {
+				return err
+			}
+			for row := 1; ; row++ {
+				line, err := lr.readLine()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					if _, ok := err.(skipErr); ok {
+						p.readLine(f)
+						p.ignoreLine(f)
+						continue
+					}
+					select {
+					case <-stopped:
+						return nil
+					default:
+						return exit.MakeIOErr(err)
+					}
+				}
+				p.readLine(f)
+				sendCh <- lineData{row, line, f}
+			}
+		}
+		return stop, wait, nil
+	}
+
+	if err := checkFileCompression(f.name); err != nil {
+		return nil, nil, err
+	}
+	file, err := tail.TailFile(f.name, tailConfig())
+	if err != nil {
+		return nil, nil, exit.MakeIOErr(err)
+	}
+	stop = func() { file.Stop() }
+	wait = func() error {
+		row := 0
+		for line := range file.Lines {
+			if line.Err != nil {
+				return exit.MakeIOErr(line.Err)
+			}
+			// Line increment
+			row++
+			p.readLine(f)
+			if len(line.Text) > p.MaxLineLen {
+				p.ignoreLine(f)
+				continue
+			}
+			sendCh <- lineData{row, line.Text, f}
+		}
+		return nil
+	}
+	return stop, wait, nil
+}
+
+func checkFileCompression(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return exit.MakeIOErr(err)
+	}
+	defer f.Close()
+	return checkCompression(name, bufio.NewReader(f))
+}
@@ -0,0 +1,146 @@
+package poi
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// lookup returns the label in access log, and the key of yaml for
+// the logical name like "uri", "time" or "host". Unknown names are
+// returned as they are.
+func (l Label) lookup(name string) (label, key string, ok bool) {
+	switch name {
+	case "uri":
+		return l.URILabel, "uri_label", true
+	case "method":
+		return l.MethodLabel, "method_label", true
+	case "status":
+		return l.StatusLabel, "status_label", true
+	case "size":
+		return l.SizeLabel, "size_label", true
+	case "apptime":
+		return l.ApptimeLabel, "apptime_label", true
+	case "reqtime":
+		return l.ReqtimeLabel, "reqtime_label", true
+	case "time":
+		return l.TimeLabel, "time_label", true
+	case "host":
+		return l.HostLabel, "host_label", true
+	}
+	return name, "", false
+}
+
+// resolve returns the label in access log for the logical name
+func (l Label) resolve(name string) string {
+	label, _, _ := l.lookup(name)
+	return label
+}
+
+// wantLabel is labels which one of them should appear in access log
+type wantLabel struct {
+	by     string // yaml key or option which requires labels
+	labels []string
+}
+
+// labelCheck records labels seen in the first lines of access log
+// to report configured labels which are never seen.
+type labelCheck struct {
+	full  int32 // set to 1 when max lines are checked, not to lock any more
+	mu    sync.Mutex
+	max   int
+	lines int
+	want  []wantLabel
+	seen  map[string]bool
+}
+
+// makeLabelCheck collects labels required by the label yaml and options
+func (p *Poi) makeLabelCheck() {
+	lc := &labelCheck{
+		max:  p.CheckLines,
+		seen: make(map[string]bool),
+	}
+	added := make(map[string]bool)
+	add := func(by string, labels ...string) {
+		for _, l := range labels {
+			if added[l] {
+				return
+			}
+		}
+		for _, l := range labels {
+			added[l] = true
+		}
+		lc.want = append(lc.want, wantLabel{by, labels})
+	}
+	addName := func(opt, name string) {
+		if label, key, ok := p.Label.lookup(name); ok {
+			add(key, label)
+		} else {
+			add(opt, label)
+		}
+	}
+
+	add("uri_label", p.URILabel)
+	add("method_label", p.MethodLabel)
+	add("status_label", p.StatusLabel)
+	add("size_label", p.SizeLabel)
+	if !p.noResTime {
+		add("apptime_label or reqtime_label", p.ApptimeLabel, p.ReqtimeLabel)
+	}
+	if p.needTime || p.useTime {
+		add("time_label", p.TimeLabel)
+	}
+	for _, name := range p.groupBy {
+		addName("--group-by", name)
+	}
+	for _, name := range p.filterLabels {
+		addName("--filter", name)
+	}
+	p.labelCheck = lc
+}
+
+// check records labels of tmp while in the first lines
+func (lc *labelCheck) check(tmp map[string]string) {
+	if lc == nil || atomic.LoadInt32(&lc.full) == 1 {
+		return
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.lines >= lc.max {
+		return
+	}
+	if lc.lines++; lc.lines == lc.max {
+		atomic.StoreInt32(&lc.full, 1)
+	}
+	for k := range tmp {
+		lc.seen[k] = true
+	}
+}
+
+// report writes warnings of labels which are never seen
+func (lc *labelCheck) report(w io.Writer) {
+	if lc == nil {
+		return
+	}
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.lines == 0 {
+		return
+	}
+next:
+	for _, want := range lc.want {
+		for _, l := range want.labels {
+			if lc.seen[l] {
+				continue next
+			}
+		}
+		quoted := make([]string, len(want.labels))
+		for i, l := range want.labels {
+			quoted[i] = strconv.Quote(l)
+		}
+		fmt.Fprintf(w, "Warning: %s %s was not found in the first %d lines\n", want.by, strings.Join(quoted, " or "), lc.lines)
+	}
+}
@@ -1,165 +1,240 @@
 package poi
 
 import (
+	"math"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/Code-Hex/exit"
+	"github.com/pkg/errors"
 )
 
-type sortFunc func(i, j int) bool
+// sortMethod returns the value of tableData to sort by
+var sortMethod = map[string]func(*tableData) float64{
+	"count":   func(t *tableData) float64 { return float64(t.count) },
+	"min":     func(t *tableData) float64 { return t.minTime },
+	"max":     func(t *tableData) float64 { return t.maxTime },
+	"sum":     func(t *tableData) float64 { return t.sumTime },
+	"time":    func(t *tableData) float64 { return t.sumTime }, // %TIME
+	"avg":     func(t *tableData) float64 { return t.avgTime },
+	"stdev":   func(t *tableData) float64 { return t.stdev },
+	"bodymin": func(t *tableData) float64 { return t.minBody },
+	"bodymax": func(t *tableData) float64 { return t.maxBody },
+	"bodyavg": func(t *tableData) float64 { return t.avgBody },
+	"2xx":     func(t *tableData) float64 { return float64(t.code2xx) },
+	"3xx":     func(t *tableData) float64 { return float64(t.code3xx) },
+	"4xx":     func(t *tableData) float64 { return float64(t.code4xx) },
+	"5xx":     func(t *tableData) float64 { return float64(t.code5xx) },
+	"err":     func(t *tableData) float64 { return t.errorRate() },
 
-var sortMethod map[string]func(bool) sortFunc
+	// Rates are in proportion to these in the same span
+	"rps": func(t *tableData) float64 { return float64(t.count) },
+	"bps": func(t *tableData) float64 { return t.sumBody },
+}
 
-func init() {
-	sortMethod = make(map[string]func(bool) sortFunc, 13)
+// makeSortBy validates the label of --sort-by. A percentile which is
+// not shown is also calculated to sort by it.
+func (p *Poi) makeSortBy() error {
+	by := strings.Split(p.Sortby, ",")[0]
+	if _, ok := sortMethod[by]; ok {
+		return nil
+	}
+	if strings.HasPrefix(by, "p") {
+		n, err := strconv.ParseFloat(by[1:], 64)
+		if err == nil && 0 < n && n <= 100 {
+			i := len(p.calcPercentiles)
+			p.calcPercentiles = append(p.calcPercentiles[:i:i], n)
+			sortMethod[by] = func(t *tableData) float64 { return t.percentiles[i] }
+			return nil
+		}
+	}
+	return exit.MakeDataErr(errors.Errorf("unknown label of --sort-by: %s", by))
 }
 
 type dict struct {
+	mu            sync.RWMutex
 	start, rownum int
 	keys          []string
 	m             map[string]*tableData
+	uris          map[string]map[string]bool // raw URIs aggregated into each uri
+	timeline      map[int64]int              // number of requests per second of the time label
+	first, last   time.Time                  // range of the time label
+	window        time.Duration              // span of results in the window, or zero
 }
 
 func newDict() *dict {
-	d := &dict{
-		keys: make([]string, 0),
-		m:    make(map[string]*tableData),
-	}
-	sortMethod["count"] = func(desc bool) sortFunc {
-		return func(i, j int) bool {
-			if desc {
-				return d.get(d.keys[i]).count > d.get(d.keys[j]).count
-			}
-			return d.get(d.keys[i]).count < d.get(d.keys[j]).count
-		}
+	return &dict{
+		keys:     make([]string, 0),
+		m:        make(map[string]*tableData),
+		uris:     make(map[string]map[string]bool),
+		timeline: make(map[int64]int),
 	}
-	sortMethod["min"] = func(desc bool) sortFunc {
-		return func(i, j int) bool {
-			if desc {
-				return d.get(d.keys[i]).minTime > d.get(d.keys[j]).minTime
-			}
-			return d.get(d.keys[i]).minTime < d.get(d.keys[j]).minTime
-		}
-	}
-	sortMethod["max"] = func(desc bool) sortFunc {
-		return func(i, j int) bool {
-			if desc {
-				return d.get(d.keys[i]).maxTime > d.get(d.keys[j]).maxTime
-			}
-			return d.get(d.keys[i]).maxTime < d.get(d.keys[j]).maxTime
-		}
-	}
-	sortMethod["avg"] = func(desc bool) sortFunc {
-		return func(i, j int) bool {
-			if desc {
-				return d.get(d.keys[i]).avgTime > d.get(d.keys[j]).avgTime
-			}
-			return d.get(d.keys[i]).avgTime < d.get(d.keys[j]).avgTime
-		}
-	}
-	sortMethod["avg"] = func(desc bool) sortFunc {
-		return func(i, j int) bool {
-			if desc {
-				return d.get(d.keys[i]).stdev > d.get(d.keys[j]).stdev
-			}
-			return d.get(d.keys[i]).stdev < d.get(d.keys[j]).stdev
-		}
+}
+
+// addURI adds raw which is aggregated into uri
+func (d *dict) addURI(uri, raw string) {
+	d.mu.Lock()
+	raws, ok := d.uris[uri]
+	if !ok {
+		raws = make(map[string]bool)
+		d.uris[uri] = raws
 	}
-	sortMethod["p10"] = func(desc bool) sortFunc {
-		return func(i, j int) bool {
-			if desc {
-				return d.get(d.keys[i]).p10 > d.get(d.keys[j]).p10
-			}
-			return d.get(d.keys[i]).p10 < d.get(d.keys[j]).p10
-		}
+	raws[raw] = true
+	d.mu.Unlock()
+}
+
+// totalURIs returns number of distinct URIs
+func (d *dict) totalURIs() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.uris)
+}
+
+// addTime counts a request at t in timeline
+func (d *dict) addTime(t time.Time) {
+	d.mu.Lock()
+	d.timeline[t.Unix()]++
+	if d.first.IsZero() || t.Before(d.first) {
+		d.first = t
 	}
-	sortMethod["p50"] = func(desc bool) sortFunc {
-		return func(i, j int) bool {
-			if desc {
-				return d.get(d.keys[i]).p50 > d.get(d.keys[j]).p50
-			}
-			return d.get(d.keys[i]).p50 < d.get(d.keys[j]).p50
-		}
+	if t.After(d.last) {
+		d.last = t
 	}
-	sortMethod["p90"] = func(desc bool) sortFunc {
-		return func(i, j int) bool {
-			if desc {
-				return d.get(d.keys[i]).p90 > d.get(d.keys[j]).p90
-			}
-			return d.get(d.keys[i]).p90 < d.get(d.keys[j]).p90
-		}
+	d.mu.Unlock()
+}
+
+// span returns seconds in which results of d are observed, at least 1,
+// or 0 if the time label is never found
+func (d *dict) span() float64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.window > 0 {
+		return math.Max(d.window.Seconds(), 1)
 	}
-	sortMethod["p95"] = func(desc bool) sortFunc {
-		return func(i, j int) bool {
-			if desc {
-				return d.get(d.keys[i]).p95 > d.get(d.keys[j]).p95
-			}
-			return d.get(d.keys[i]).p95 < d.get(d.keys[j]).p95
-		}
+	if d.first.IsZero() {
+		return 0
 	}
-	sortMethod["p99"] = func(desc bool) sortFunc {
-		return func(i, j int) bool {
-			if desc {
-				return d.get(d.keys[i]).p99 > d.get(d.keys[j]).p99
-			}
-			return d.get(d.keys[i]).p99 < d.get(d.keys[j]).p99
-		}
+	return math.Max(d.last.Sub(d.first).Seconds(), 1)
+}
+
+// updateRatios calculates values relative to all data, the share of
+// response time, and requests and bytes per second. Rates are NaN if
+// the span is unknown.
+func (d *dict) updateRatios() {
+	span := d.span()
+	d.mu.RLock()
+	// Sum up in sorted order not to depend on the order of keys
+	sums := make([]float64, 0, len(d.m))
+	for _, val := range d.m {
+		sums = append(sums, val.sumTime)
 	}
-	sortMethod["bodymin"] = func(desc bool) sortFunc {
-		return func(i, j int) bool {
-			if desc {
-				return d.get(d.keys[i]).minBody > d.get(d.keys[j]).minBody
-			}
-			return d.get(d.keys[i]).minBody < d.get(d.keys[j]).minBody
-		}
+	sort.Float64s(sums)
+	var total float64
+	for _, s := range sums {
+		total += s
 	}
-	sortMethod["bodymax"] = func(desc bool) sortFunc {
-		return func(i, j int) bool {
-			if desc {
-				return d.get(d.keys[i]).maxBody > d.get(d.keys[j]).maxBody
-			}
-			return d.get(d.keys[i]).maxBody < d.get(d.keys[j]).maxBody
+	for _, val := range d.m {
+		val.timeShare = 0
+		if total > 0 {
+			val.timeShare = val.sumTime / total * 100
 		}
-	}
-	sortMethod["bodyavg"] = func(desc bool) sortFunc {
-		return func(i, j int) bool {
-			if desc {
-				return d.get(d.keys[i]).avgBody > d.get(d.keys[j]).avgBody
-			}
-			return d.get(d.keys[i]).avgBody < d.get(d.keys[j]).avgBody
+		val.rps, val.bps = math.NaN(), math.NaN()
+		if span > 0 {
+			val.rps = float64(val.count) / span
+			val.bps = val.sumBody / span
 		}
 	}
-	return d
+	d.mu.RUnlock()
+}
+
+// makeKey makes a key of dict from values of --group-by labels.
+// They are joined by NUL which would never be in the values.
+func makeKey(keys []string) string {
+	return strings.Join(keys, "\x00")
 }
 
 func (d *dict) set(key string, val *tableData) {
-	mu.Lock()
+	d.mu.Lock()
 	if _, ok := d.m[key]; !ok {
 		d.keys = append(d.keys, key)
 	}
 	d.m[key] = val
-	mu.Unlock()
+	d.mu.Unlock()
 }
 
 func (d *dict) get(key string) *tableData {
-	mu.RLock()
-	defer mu.RUnlock()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
 	if v, ok := d.m[key]; ok {
 		return v
 	}
 	return nil
 }
 
+// merge adds all data of other into d
+func (d *dict) merge(other *dict) {
+	for _, key := range other.keys {
+		val := other.m[key]
+		if dst := d.get(key); dst != nil {
+			dst.merge(val)
+		} else {
+			d.set(key, val)
+		}
+	}
+	d.mu.Lock()
+	for uri, raws := range other.uris {
+		dst, ok := d.uris[uri]
+		if !ok {
+			d.uris[uri] = raws
+			continue
+		}
+		for raw := range raws {
+			dst[raw] = true
+		}
+	}
+	for sec, n := range other.timeline {
+		d.timeline[sec] += n
+	}
+	if !other.first.IsZero() && (d.first.IsZero() || other.first.Before(d.first)) {
+		d.first = other.first
+	}
+	if other.last.After(d.last) {
+		d.last = other.last
+	}
+	d.mu.Unlock()
+}
+
+// finalize calculates statistics of all data
+func (d *dict) finalize(percentiles []float64) {
+	for _, val := range d.m {
+		val.finalize(percentiles)
+	}
+}
+
 func (d *dict) setRow(r int) {
-	mu.Lock()
+	d.mu.Lock()
 	d.rownum = r
-	mu.Unlock()
+	d.mu.Unlock()
 }
 
 func (d *dict) resetRangeInfo() {
-	mu.Lock()
+	d.mu.Lock()
 	d.start = 0
 	d.rownum = len(d.keys)
-	mu.Unlock()
+	d.mu.Unlock()
+}
+
+// allKeys returns a copy of all keys in the order of values
+func (d *dict) allKeys() []string {
+	d.mu.RLock()
+	keys := make([]string, len(d.keys))
+	copy(keys, d.keys)
+	d.mu.RUnlock()
+	sort.Strings(keys)
+	return keys
 }
 
 func (d *dict) sortedKeys(by string) []string {
@@ -173,11 +248,22 @@ func (d *dict) sortedKeys(by string) []string {
 		by = sortedBy
 	}
 
-	if c, ok := sortMethod[by]; ok {
-		sort.Slice(d.keys, c(desc))
-	} else {
-		sort.Slice(d.keys, sortMethod["count"](desc))
-	}
+	value := sortMethod[by] // validated by makeSortBy
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sort.Slice(d.keys, func(i, j int) bool {
+		vi, vj := value(d.m[d.keys[i]]), value(d.m[d.keys[j]])
+		if vi == vj {
+			// To be the same order whatever the keys are inserted
+			return d.keys[i] < d.keys[j]
+		}
+		if desc {
+			return vi > vj
+		}
+		return vi < vj
+	})
 
 	l := len(d.keys)
 	if d.start+d.rownum < l {
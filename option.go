@@ -6,6 +6,7 @@ import (
 	"os"
 
 	"reflect"
+	"time"
 
 	flags "github.com/jessevdk/go-flags"
 	"github.com/pkg/errors"
@@ -18,13 +19,44 @@ type Options struct {
 	Help    bool `short:"h" long:"help" description:"show this message"`
 	Version bool `short:"v" long:"version" description:"print the version"`
 
-	TailMode   bool   `short:"t" long:"tail" description:"monitor the file and update the results in realtime"`
-	Expand     bool   `short:"x" long:"expand" description:"display more detailed information"`
-	Filename   string `short:"f" long:"file" required:"true" description:"specify the file of ltsv format access log"`
-	Sortby     string `long:"sort-by" default:"count,desc" description:"specify a format like 'label,order' for sorting"`
-	LabelAs    string `long:"label-as" description:"specify a yaml file with key and value for access log"`
-	Limit      int    `short:"l" long:"limit" default:"5000" description:"specify a maximum line ranges for access log to use"`
-	StackTrace bool   `long:"trace" description:"display detail error messages"`
+	TailMode   bool     `short:"t" long:"tail" description:"monitor the file and update the results in realtime"`
+	Expand     bool     `short:"x" long:"expand" description:"display more detailed information"`
+	StatusCode bool     `short:"s" long:"status-code" description:"display the number of each status code class and the rate of 5xx"`
+	Rate       bool     `short:"r" long:"rate" description:"display requests and bytes per second over the span of the time label"`
+	Sum        bool     `long:"sum" description:"display the sum of response time and its percentage in all"`
+	Filenames  []string `short:"f" long:"file" required:"true" description:"specify the file of access log, '-' for stdin, can be repeated or a glob"`
+	Format     string   `long:"format" description:"specify the format of access log (ltsv, combined, common, jsonl, regexp), combined-D or combined-T for the response time of %D or %T at the end"`
+	Pattern    string   `long:"pattern" unquote:"false" description:"specify a regexp with named groups for --format regexp"`
+	Output     string   `short:"o" long:"output" default:"table" description:"specify the output format (table, json, csv, tsv, markdown, html)"`
+	Title      string   `long:"title" description:"specify the title of markdown or html output"`
+	Summary    bool     `long:"summary" description:"display read and ignored lines and total URIs above markdown output"`
+	Listen     string   `long:"listen" description:"specify an address like ':9100' to expose metrics for Prometheus on /metrics with --tail"`
+
+	Window     time.Duration `long:"window" description:"specify a duration like '5m' to show only recent requests in the terminal UI of --tail, toggled by 'w' key"`
+	NoTUI      bool          `long:"no-tui" description:"follow the file with --tail without the terminal UI, and write a report every --interval"`
+	Interval   time.Duration `long:"interval" default:"1m" description:"specify the interval to write reports with --no-tui"`
+	ReportFile string        `long:"report-file" description:"specify a file to write reports instead of stdout, old ones are rotated like file.1"`
+	ReportKeep int           `long:"report-keep" default:"5" description:"specify the number of rotated report files to keep"`
+	Reset      bool          `long:"reset" description:"reset the results after writing each report, so that it covers one interval"`
+	Sortby     string        `long:"sort-by" default:"count,desc" description:"specify a format like 'label,order' for sorting"`
+	GroupBy    string        `long:"group-by" default:"method,uri" description:"specify comma-separated labels to aggregate by"`
+	Filter     string        `long:"filter" unquote:"false" description:"specify an expression to select lines like 'status >= 500'"`
+	Since      string        `long:"since" description:"specify the start time like '2006-01-02T15:04:05+09:00' or '--since=-1h' from now"`
+	Until      string        `long:"until" description:"specify the end time like '2006-01-02T15:04:05+09:00' or '--until=-30m' from now"`
+	TimeFormat string        `long:"time-format" description:"specify the layout of time label (rfc3339, apache, epoch or Go layout), default is auto"`
+	LabelAs    string        `long:"label-as" description:"specify a yaml file with key and value for access log"`
+	Limit      int           `short:"l" long:"limit" default:"5000" description:"specify a maximum line ranges for access log to use"`
+	MaxLineLen int           `long:"max-line-length" default:"1048576" description:"specify a maximum bytes of a line, longer lines are ignored"`
+	CheckLines int           `long:"check-lines" default:"1000" description:"specify the number of lines to warn about labels never seen, 0 to disable"`
+	StackTrace bool          `long:"trace" description:"display detail error messages"`
+	PerFile    bool          `long:"per-file" description:"display read and ignored lines for each file"`
+	Workers    int           `long:"workers" description:"specify the number of workers to parse access log, default is number of CPUs"`
+	Precise    bool          `long:"precise" description:"calculate exact percentiles by keeping all response times on memory"`
+
+	PercentileList string `long:"percentiles" description:"specify comma-separated percentiles to display like '50,99,99.9'"`
+
+	MatchingGroups []string `short:"m" long:"matching-groups" unquote:"false" description:"specify a regexp to aggregate matching URIs, can be repeated"`
+	AutoNormalize  bool     `long:"auto-normalize" description:"replace ids, uuids and hashes in URIs with placeholders"`
 }
 
 func (opts *Options) parse(argv []string) ([]string, error) {
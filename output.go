@@ -0,0 +1,342 @@
+package poi
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Code-Hex/exit"
+	"github.com/pkg/errors"
+)
+
+// makeOutput validates --output
+func (p *Poi) makeOutput() error {
+	switch p.Output {
+	case "table":
+	case "json", "csv", "tsv", "markdown", "html":
+		if p.TailMode && !p.NoTUI {
+			return exit.MakeDataErr(errors.Errorf("--output %s needs --no-tui with --tail", p.Output))
+		}
+	default:
+		return exit.MakeDataErr(errors.Errorf("unknown output format %q", p.Output))
+	}
+	if p.Listen != "" && !p.TailMode {
+		return exit.MakeDataErr(errors.New("--listen needs --tail"))
+	}
+	if p.NoTUI && !p.TailMode {
+		return exit.MakeDataErr(errors.New("--no-tui needs --tail"))
+	}
+	if (p.Reset || p.ReportFile != "") && !p.NoTUI {
+		return exit.MakeDataErr(errors.New("--reset and --report-file need --no-tui"))
+	}
+	if p.NoTUI && p.Interval <= 0 {
+		return exit.MakeDataErr(errors.Errorf("invalid --interval %s", p.Interval))
+	}
+	return nil
+}
+
+// render writes the result by --output
+func (p *Poi) render(w io.Writer) error {
+	dataMap.updateRatios()
+	switch p.Output {
+	case "json":
+		return p.renderJSON(w)
+	case "csv":
+		return p.renderCSV(w, ',')
+	case "tsv":
+		return p.renderCSV(w, '\t')
+	case "markdown":
+		return p.renderMarkdown(w)
+	case "html":
+		return p.renderHTML(w)
+	}
+	p.renderTable(w)
+	if p.PerFile {
+		p.renderFiles(w)
+	}
+	return nil
+}
+
+// renderCSV writes the header and rows separated by comma.
+// Numbers are written at full precision for spreadsheets.
+func (p *Poi) renderCSV(w io.Writer, comma rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	cw.Write(p.header)
+	for _, key := range dataMap.sortedKeys(p.Sortby) {
+		cw.Write(p.cells(dataMap.get(key), true))
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return exit.MakeIOErr(errors.Wrap(err, "Failed to write csv"))
+	}
+	return nil
+}
+
+// renderMarkdown writes a GitHub flavored table with the optional
+// --title and --summary lines
+func (p *Poi) renderMarkdown(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if p.Title != "" {
+		fmt.Fprintf(bw, "## %s\n\n", p.Title)
+	}
+	if p.Summary {
+		mu.RLock()
+		fmt.Fprintf(bw, "Read lines: %d, Ignore lines: %d", p.row, p.ignored)
+		if p.filter != nil || p.needTime {
+			fmt.Fprintf(bw, ", Filtered lines: %d", p.filtered)
+		}
+		fmt.Fprintf(bw, ", Total URI: %d\n\n", dataMap.totalURIs())
+		mu.RUnlock()
+	}
+
+	// Numbers are right-aligned, labels of --group-by are left-aligned
+	aligns := make([]string, len(p.header))
+	for i := range p.header {
+		if i < p.keyIdx {
+			aligns[i] = "---:"
+		} else {
+			aligns[i] = ":---"
+		}
+	}
+	writeMarkdownRow(bw, p.header)
+	writeMarkdownRow(bw, aligns)
+	for _, key := range dataMap.sortedKeys(p.Sortby) {
+		writeMarkdownRow(bw, p.cells(dataMap.get(key), false))
+	}
+
+	if p.PerFile {
+		fmt.Fprintln(bw)
+		writeMarkdownRow(bw, []string{"FILE", "READ", "IGNORE", "FILTER"})
+		writeMarkdownRow(bw, []string{":---", "---:", "---:", "---:"})
+		for _, f := range p.files {
+			writeMarkdownRow(bw, []string{
+				f.name,
+				strconv.Itoa(f.read),
+				strconv.Itoa(f.ignored),
+				strconv.Itoa(f.filtered),
+			})
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return exit.MakeIOErr(errors.Wrap(err, "Failed to write markdown"))
+	}
+	return nil
+}
+
+var markdownEscaper = strings.NewReplacer("|", "\\|", "\n", " ")
+
+func writeMarkdownRow(w io.Writer, cells []string) {
+	io.WriteString(w, "|")
+	for _, c := range cells {
+		io.WriteString(w, " "+markdownEscaper.Replace(c)+" |")
+	}
+	io.WriteString(w, "\n")
+}
+
+// formatFull formats v at full precision like "0.1234567"
+func formatFull(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// Schema of --output json
+//
+//	{
+//	  "version": 1,
+//	  "meta": {
+//	    "group_by": ["method", "uri"],
+//	    "sort_by": "count,desc",
+//	    "percentiles": [50, 99],
+//	    "read_lines": 22,
+//	    "ignored_lines": 0,
+//	    "filtered_lines": 0,
+//	    "total_uris": 4,
+//	    "span_seconds": 158,
+//	    "files": [
+//	      {"name": "access.log", "read_lines": 22, "ignored_lines": 0, "filtered_lines": 0}
+//	    ]
+//	  },
+//	  "results": [
+//	    {
+//	      "keys": {"method": "GET", "uri": "/foo"},
+//	      "count": 11,
+//	      "response_time": {"min": 0.1, "max": 0.2, "sum": 1.65, "share": 18.2, "avg": 0.15, "stdev": 0.05, "percentiles": {"p50": 0.15, "p99": 0.2}},
+//	      "body_size": {"min": 12, "max": 56, "avg": 36, "sum": 396},
+//	      "status": {"2xx": 11, "3xx": 0, "4xx": 0, "5xx": 0, "error_rate": 0},
+//	      "rate": {"rps": 0.07, "bps": 2.51},
+//	      "uris": 2
+//	    }
+//	  ]
+//	}
+//
+// Results are in the order of --sort-by. Response times are in seconds
+// and error_rate is the percentage of 5xx. "share" is the percentage of
+// "sum" in all keys. "uris" is the number of raw URIs aggregated into
+// the key, only present with --matching-groups or --auto-normalize.
+// "span_seconds" and "rate" are only present with --rate and the time
+// label, rates are per the span of the time label. "percentiles" are of
+// --percentiles, or the defaults if not specified. Fields are never
+// renamed or removed in the same version, but new fields may be added.
+const jsonVersion = 1
+
+type jsonReport struct {
+	Version int          `json:"version"`
+	Meta    jsonMeta     `json:"meta"`
+	Results []jsonResult `json:"results"`
+}
+
+type jsonMeta struct {
+	GroupBy       []string   `json:"group_by"`
+	SortBy        string     `json:"sort_by"`
+	Percentiles   []float64  `json:"percentiles"`
+	ReadLines     int        `json:"read_lines"`
+	IgnoredLines  int        `json:"ignored_lines"`
+	FilteredLines int        `json:"filtered_lines"`
+	TotalURIs     int        `json:"total_uris"`
+	SpanSeconds   float64    `json:"span_seconds,omitempty"`
+	Files         []jsonFile `json:"files"`
+}
+
+type jsonFile struct {
+	Name          string `json:"name"`
+	ReadLines     int    `json:"read_lines"`
+	IgnoredLines  int    `json:"ignored_lines"`
+	FilteredLines int    `json:"filtered_lines"`
+}
+
+type jsonResult struct {
+	Keys         map[string]string `json:"keys"`
+	Count        int               `json:"count"`
+	ResponseTime jsonResponseTime  `json:"response_time"`
+	BodySize     jsonBodySize      `json:"body_size"`
+	Status       jsonStatus        `json:"status"`
+	Rate         *jsonRate         `json:"rate,omitempty"`
+	URIs         *int              `json:"uris,omitempty"`
+}
+
+type jsonRate struct {
+	RPS float64 `json:"rps"`
+	BPS float64 `json:"bps"`
+}
+
+type jsonResponseTime struct {
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Sum         float64            `json:"sum"`
+	Share       float64            `json:"share"`
+	Avg         float64            `json:"avg"`
+	Stdev       float64            `json:"stdev"`
+	Percentiles map[string]float64 `json:"percentiles"`
+}
+
+type jsonBodySize struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+	Avg float64 `json:"avg"`
+	Sum float64 `json:"sum"`
+}
+
+type jsonStatus struct {
+	Code2xx   int     `json:"2xx"`
+	Code3xx   int     `json:"3xx"`
+	Code4xx   int     `json:"4xx"`
+	Code5xx   int     `json:"5xx"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+func (p *Poi) renderJSON(w io.Writer) error {
+	report := jsonReport{
+		Version: jsonVersion,
+		Meta:    p.jsonMeta(),
+		Results: make([]jsonResult, 0, len(dataMap.keys)),
+	}
+	for _, key := range dataMap.sortedKeys(p.Sortby) {
+		report.Results = append(report.Results, p.jsonResult(dataMap.get(key)))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return exit.MakeIOErr(errors.Wrap(err, "Failed to write json"))
+	}
+	return nil
+}
+
+func (p *Poi) jsonMeta() jsonMeta {
+	mu.RLock()
+	defer mu.RUnlock()
+	meta := jsonMeta{
+		GroupBy:       p.groupBy,
+		SortBy:        p.Sortby,
+		Percentiles:   p.percentiles,
+		ReadLines:     p.row,
+		IgnoredLines:  p.ignored,
+		FilteredLines: p.filtered,
+		TotalURIs:     dataMap.totalURIs(),
+		Files:         make([]jsonFile, 0, len(p.files)),
+	}
+	if p.Rate {
+		meta.SpanSeconds = dataMap.span()
+	}
+	if meta.Percentiles == nil {
+		meta.Percentiles = []float64{}
+	}
+	for _, f := range p.files {
+		meta.Files = append(meta.Files, jsonFile{
+			Name:          f.name,
+			ReadLines:     f.read,
+			IgnoredLines:  f.ignored,
+			FilteredLines: f.filtered,
+		})
+	}
+	return meta
+}
+
+func (p *Poi) jsonResult(val *tableData) jsonResult {
+	r := jsonResult{
+		Keys:  make(map[string]string, len(p.groupBy)),
+		Count: val.count,
+		ResponseTime: jsonResponseTime{
+			Min:         val.minTime,
+			Max:         val.maxTime,
+			Sum:         val.sumTime,
+			Share:       val.timeShare,
+			Avg:         val.avgTime,
+			Stdev:       val.stdev,
+			Percentiles: make(map[string]float64, len(p.percentiles)),
+		},
+		BodySize: jsonBodySize{
+			Min: val.minBody,
+			Max: val.maxBody,
+			Avg: val.avgBody,
+			Sum: val.sumBody,
+		},
+		Status: jsonStatus{
+			Code2xx:   val.code2xx,
+			Code3xx:   val.code3xx,
+			Code4xx:   val.code4xx,
+			Code5xx:   val.code5xx,
+			ErrorRate: val.errorRate(),
+		},
+	}
+	for i, name := range p.groupBy {
+		r.Keys[name] = val.keys[i]
+	}
+	for i, n := range p.percentiles {
+		r.ResponseTime.Percentiles[strings.ToLower(percentileHeader(n))] = val.percentiles[i]
+	}
+	if p.Rate && !math.IsNaN(val.rps) {
+		r.Rate = &jsonRate{RPS: val.rps, BPS: val.bps}
+	}
+	if p.isGrouping() && p.uriKeyIdx >= 0 {
+		n := p.countURIs(val.keys[p.uriKeyIdx])
+		r.URIs = &n
+	}
+	return r
+}
@@ -1,14 +1,16 @@
 package poi
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"math"
+	"io"
 	"net/url"
+	"os"
+	"regexp"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"golang.org/x/sync/errgroup"
 
@@ -25,25 +27,48 @@ import (
 
 var mu sync.RWMutex
 
+// dataMu guards values of dataMap which are updated in tail mode
+var dataMu sync.RWMutex
+
 // Poi is main struct for command line
 type Poi struct {
 	Options
-	Label
+	Config
 
 	// window size
 	width, height int
 
 	// Related with access log data
-	header     []string
-	posXlist   []int
-	headerPosY int
-	uriMap     map[string]bool
-	lineData   []*data
-	curLine    int
-	dataIdx    int
+	header          []string
+	percentiles     []float64
+	percentileIdx   int       // index of the first percentile in header
+	calcPercentiles []float64 // percentiles to calculate, with ones of metrics and --sort-by not shown
+	keyIdx          int       // index of the first --group-by label in header
+	groupBy         []string
+	uriKeyIdx       int // index of "uri" in groupBy, or -1
+	posXlist        []int
+	headerPosY      int
+	groups          []*regexp.Regexp
+	parse           lineParser
+	filter          predicate
+	filterLabels    []string
+	labelCheck      *labelCheck
+	timeLayouts     []string
+	since, until    time.Time
+	needTime        bool // whether parse the time label
+	noResTime       bool // whether the format has no response time
+	useTime         bool // whether parse the time label if it exists
+	files           []*logFile
+	window          *slidingWindow // results of --window
+	showWindow      bool           // whether the top pane shows the window
+	lineData        []*data
+	curLine         int
+	dataIdx         int
 
 	// Logged for row number
-	row int
+	row      int
+	ignored  int
+	filtered int
 
 	// Tasks
 	count int
@@ -55,37 +80,47 @@ type data struct {
 }
 
 type lineData struct {
-	row int
-	tmp map[string]string
+	row  int
+	text string
+	file *logFile
 }
 
 type tableData struct {
+	keys                               []string // values of --group-by labels
 	count                              int
 	minTime, maxTime, avgTime          float64
+	sumTime, timeShare                 float64 // timeShare is the percentage of sumTime in all
 	stdev                              float64
-	p10, p50, p90, p95, p99            float64
+	percentiles                        []float64
 	maxBody, minBody, avgBody          float64
+	sumBody                            float64
 	code2xx, code3xx, code4xx, code5xx int
-	responseTimes                      []float64
+	rps, bps                           float64 // requests and bytes per second by --rate
+
+	// Running mean and sum of squares of differences from the mean
+	mean, m2 float64
+	times    quantileSketch
 }
 
 type parsedLabel struct {
+	keys        []string // values of --group-by labels
 	uri, method string
+	rawURI      string // path of the uri before grouped
 	statusCode  string
 	resTime     float64
 	bodySize    float64
+	timestamp   time.Time // zero unless needTime
 }
 
 var (
-	dataMap *dict
-	skip    = makeSkipError()
+	dataMap  *dict
+	skip     = makeSkipError()
+	filtered = makeFilterError()
 )
 
 // New return pointered "poi" struct
 func New() *Poi {
-	return &Poi{
-		uriMap: make(map[string]bool),
-	}
+	return &Poi{}
 }
 
 func (p *Poi) init() {
@@ -100,129 +135,343 @@ func (p *Poi) init() {
 
 	p.header = append(p.header,
 		"COUNT",
-		"MIN", "MAX", "AVG",
+		"MIN", "MAX",
+	)
+
+	if p.Sum {
+		p.header = append(p.header, "SUM", "%TIME")
+	}
+
+	p.header = append(p.header,
+		"AVG",
 		"STDEV",
 	)
 
-	if p.Expand {
-		p.header = append(p.header,
-			"P10", "P50", "P90", "P95", "P99",
-		)
+	// Percentiles are next to "STDEV"
+	p.percentileIdx = len(p.header)
+	for _, n := range p.percentiles {
+		p.header = append(p.header, percentileHeader(n))
 	}
 
 	p.header = append(p.header,
 		"BODYMIN", "BODYMAX", "BODYAVG",
-		"METHOD", "URI",
 	)
 
+	if p.Rate {
+		p.header = append(p.header, "RPS", "BPS")
+	}
+
+	if p.StatusCode {
+		p.header = append(p.header,
+			"2XX", "3XX", "4XX", "5XX", "ERR%",
+		)
+	}
+
+	if p.isGrouping() && p.uriKeyIdx >= 0 {
+		p.header = append(p.header, "URIS")
+	}
+
+	// Labels of --group-by are at the end
+	p.keyIdx = len(p.header)
+	for _, name := range p.groupBy {
+		p.header = append(p.header, strings.ToUpper(name))
+	}
+
 	// Allocate for header
 	p.posXlist = make([]int, len(p.header), len(p.header))
 
 	// dataMap on the global
 	dataMap = newDict()
+	if p.Window > 0 {
+		p.window = newSlidingWindow(p.Window)
+		p.showWindow = true
+	}
 }
 
 func (p *Poi) analyze() error {
 	p.init()
+	defer p.labelCheck.report(os.Stderr)
+	if p.noResTime {
+		defer p.warnNoResTime(os.Stderr)
+	}
 	if p.TailMode {
 		return p.tailmode()
 	}
 	return p.normalmode()
 }
 
+// Number of lines sent to a worker at once in normalmode
+const chunkSize = 1024
+
+type chunk struct {
+	seq   int // sequence number of chunks in all files
+	lines []lineData
+}
+
+// partial is the result of a chunk
+type partial struct {
+	seq int
+	d   *dict
+}
+
 func (p *Poi) normalmode() error {
-	b, err := ioutil.ReadFile(p.Filename)
-	if err != nil {
-		return exit.MakeIOErr(err)
+	workers := p.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
 	}
 
-	sc := bufio.NewScanner(bytes.NewReader(b))
-	for l := 1; sc.Scan(); l++ {
-		data := parseLTSV(sc.Text())
-		label, err := p.parseLabel(data)
-		if err != nil {
-			if _, ok := err.(skipErr); ok {
-				continue
+	grp, ctx := errgroup.WithContext(context.Background())
+	chunkCh := make(chan chunk, workers*2)
+	partialCh := make(chan partial, workers*2)
+
+	grp.Go(func() error {
+		defer close(chunkCh)
+		seq := 0
+		send := func(lines []lineData) error {
+			select {
+			case chunkCh <- chunk{seq, lines}:
+				seq++
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-			return exit.MakeSoftWare(errors.Wrap(err, fmt.Sprintf("at line: %d", l)))
 		}
-		p.makeResult(label)
+		for _, f := range p.files {
+			if err := p.readFile(f, send); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	// Each worker aggregates a chunk into its own dict, and counts
+	// lines by itself not to lock per line
+	var wg sync.WaitGroup
+	counts := make([]lineCounts, workers)
+	for n := 0; n < workers; n++ {
+		cnt := make(lineCounts)
+		counts[n] = cnt
+		wg.Add(1)
+		grp.Go(func() error {
+			defer wg.Done()
+			for c := range chunkCh {
+				d := newDict()
+				for _, line := range c.lines {
+					_, label, err := p.parseLine(line.text)
+					if err != nil {
+						switch err.(type) {
+						case skipErr:
+							cnt.of(line.file).ignored++
+							continue
+						case filterErr:
+							cnt.of(line.file).filtered++
+							continue
+						}
+						return exit.MakeSoftWare(errors.Wrap(err, fmt.Sprintf("%s at line: %d", line.file.name, line.row)))
+					}
+					p.makeResult(d, label)
+				}
+				select {
+				case partialCh <- partial{c.seq, d}:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
 	}
-	if err := sc.Err(); err != nil {
-		return exit.MakeSoftWare(errors.Wrap(err, "Failed to read file"))
+	go func() {
+		wg.Wait()
+		close(partialCh)
+	}()
+
+	// Results are merged in order of chunks, so that sums of floats are
+	// the same whatever the number of workers is
+	grp.Go(func() error {
+		pending := make(map[int]*dict)
+		next := 0
+		for r := range partialCh {
+			pending[r.seq] = r.d
+			for d, ok := pending[next]; ok; d, ok = pending[next] {
+				dataMap.merge(d)
+				delete(pending, next)
+				next++
+			}
+		}
+		return nil
+	})
+
+	if err := grp.Wait(); err != nil {
+		return err
 	}
+
+	for _, cnt := range counts {
+		p.addLines(cnt)
+	}
+	dataMap.finalize(p.calcPercentiles)
 	dataMap.rownum = len(dataMap.keys)
-	p.renderTable()
-	return nil
+	return p.render(os.Stdout)
 }
 
-func (p *Poi) tailmode() error {
-	file, err := tail.TailFile(p.Filename, tailConfig())
+// readFile sends lines of f by chunkSize
+func (p *Poi) readFile(f *logFile, send func([]lineData) error) error {
+	r, err := openFile(f.name)
 	if err != nil {
 		return exit.MakeIOErr(err)
 	}
-
-	if err := termbox.Init(); err != nil {
-		return exit.MakeSoftWare(err)
+	defer r.Close()
+
+	// Lines are counted at once when the file is read
+	cnt := make(lineCounts)
+	defer p.addLines(cnt)
+
+	lr := newLineReader(r, p.MaxLineLen)
+	c := make([]lineData, 0, chunkSize)
+	for l := 1; ; l++ {
+		text, err := lr.readLine()
+		if err == io.EOF {
+			break
+		}
+		cnt.of(f).read++
+		if err != nil {
+			if _, ok := err.(skipErr); ok {
+				cnt.of(f).ignored++
+				continue
+			}
+			return exit.MakeIOErr(errors.Wrap(err, "Failed to read file"))
+		}
+		c = append(c, lineData{l, text, f})
+		if len(c) == chunkSize {
+			if err := send(c); err != nil {
+				return err
+			}
+			c = make([]lineData, 0, chunkSize)
+		}
 	}
+	if len(c) > 0 {
+		return send(c)
+	}
+	return nil
+}
 
-	var otail sync.Once
-
+func (p *Poi) tailmode() error {
 	ncpu := runtime.NumCPU()
 
 	flush := make(chan struct{})
 	sendCh := make(chan lineData, ncpu*2)
 	labelCh := make(chan *parsedLabel, ncpu*2)
-	termbox.SetInputMode(termbox.InputEsc)
 
 	var grp errgroup.Group
-	defer termbox.Close()
 
-	grp.Go(func() error {
-		for range flush {
-			p.renderAll()
-			p.flush()
+	// Start to tail all files
+	stops := make([]func(), 0, len(p.files))
+	waits := make([]func() error, 0, len(p.files))
+	for _, f := range p.files {
+		stop, wait, err := p.tailFile(f, sendCh)
+		if err != nil {
+			for _, stop := range stops {
+				stop()
+			}
+			return err
 		}
-		return nil
-	})
-
-	grp.Go(func() error {
-		defer func() {
-			otail.Do(func() {
-				file.Stop()
-			})
-			close(sendCh)
-		}()
-
-		row := 0
-		for line := range file.Lines {
-			if line.Err != nil {
-				return exit.MakeIOErr(line.Err)
+		stops = append(stops, stop)
+		waits = append(waits, wait)
+	}
+	var ostop sync.Once
+	stopAll := func() {
+		ostop.Do(func() {
+			for _, stop := range stops {
+				stop()
 			}
-			// Line increment
-			row++
-			sendCh <- lineData{row, parseLTSV(line.Text)}
+		})
+	}
+
+	if p.Listen != "" {
+		stopServer, err := p.serveMetrics()
+		if err != nil {
+			stopAll()
+			return err
 		}
-		return nil
-	})
+		defer stopServer()
+	}
+
+	if p.NoTUI {
+		flush = nil // Reports are written by p.headless
+	} else {
+		if err := termbox.Init(); err != nil {
+			stopAll()
+			return exit.MakeSoftWare(err)
+		}
+		termbox.SetInputMode(termbox.InputEsc)
+		defer termbox.Close()
+
+		grp.Go(func() error {
+			// Old requests go out of the window without new lines
+			var tick <-chan time.Time
+			if p.window != nil {
+				ticker := time.NewTicker(windowRebuildInterval)
+				defer ticker.Stop()
+				tick = ticker.C
+			}
+			for {
+				select {
+				case _, ok := <-flush:
+					if !ok {
+						return nil
+					}
+				case <-tick:
+				}
+				p.renderAll()
+				p.flush()
+			}
+		})
+	}
+
+	var sources sync.WaitGroup
+	for _, wait := range waits {
+		wait := wait
+		sources.Add(1)
+		grp.Go(func() error {
+			defer sources.Done()
+			if err := wait(); err != nil {
+				stopAll()
+				if !p.NoTUI {
+					termbox.Interrupt() // To quit the monitor
+				}
+				return err
+			}
+			return nil
+		})
+	}
+	go func() {
+		sources.Wait()
+		close(sendCh)
+	}()
 
 	var (
 		olabel sync.Once
 		oflush sync.Once
 	)
+	done := make(chan struct{}) // closed when all lines are aggregated
 	for n := 0; n < ncpu; n++ {
 		grp.Go(func() error {
 			defer olabel.Do(func() { close(labelCh) })
 			for line := range sendCh {
-				p.setLineData(line.tmp) // This method to watch the log
-				label, err := p.parseLabel(line.tmp)
+				tmp, label, err := p.parseLine(line.text)
+				if tmp != nil {
+					p.setLineData(tmp) // This method to watch the log
+				}
 				if err != nil {
-					if _, ok := err.(skipErr); ok {
+					switch err.(type) {
+					case skipErr:
+						p.ignoreLine(line.file)
+						continue
+					case filterErr:
+						p.filterLine(line.file)
 						continue
 					}
-					return exit.MakeSoftWare(errors.Wrap(err, fmt.Sprintf("at line: %d", line.row)))
+					return exit.MakeSoftWare(errors.Wrap(err, fmt.Sprintf("%s at line: %d", line.file.name, line.row)))
 				}
-				p.row = line.row
 				labelCh <- label
 			}
 			return nil
@@ -230,18 +479,34 @@ func (p *Poi) tailmode() error {
 	}
 
 	grp.Go(func() error {
-		defer oflush.Do(func() { close(flush) })
+		defer close(done)
+		if flush != nil {
+			defer oflush.Do(func() { close(flush) })
+		}
 		for label := range labelCh {
-			p.makeResult(label)
-			flush <- struct{}{}
+			dataMu.Lock()
+			p.makeResult(dataMap, label).finalize(p.calcPercentiles)
+			if p.window != nil {
+				p.addWindow(p.window, time.Now().Unix(), label)
+			}
+			dataMu.Unlock()
+			if flush != nil {
+				flush <- struct{}{}
+			}
 		}
 		return nil
 	})
 
-	grp.Go(func() error {
-		defer otail.Do(func() {
-			file.Stop()
+	if p.NoTUI {
+		grp.Go(func() error {
+			defer stopAll()
+			return p.headless(done)
 		})
+		return grp.Wait()
+	}
+
+	grp.Go(func() error {
+		defer stopAll()
 
 	monitor:
 		for {
@@ -250,6 +515,9 @@ func (p *Poi) tailmode() error {
 				if ev.Ch == 'q' {
 					break monitor
 				}
+				if ev.Ch == 'w' {
+					p.toggleWindow()
+				}
 				// special keys
 				switch ev.Key {
 				case termbox.KeyEsc, termbox.KeyCtrlC:
@@ -264,6 +532,8 @@ func (p *Poi) tailmode() error {
 				}
 			case termbox.EventResize:
 				p.renderAll()
+			case termbox.EventInterrupt:
+				break monitor
 			case termbox.EventError:
 				return exit.MakeSoftWare(ev.Err)
 			}
@@ -296,49 +566,82 @@ func (p *Poi) isZeroTask() bool {
 	return count == 0
 }
 
+// parseLine parses a line of access log into the label map and the label
+func (p *Poi) parseLine(text string) (map[string]string, *parsedLabel, error) {
+	tmp, err := p.parse(text)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.labelCheck.check(tmp)
+	if p.filter != nil && !p.filter(tmp) {
+		return nil, nil, filtered
+	}
+	label, err := p.parseLabel(tmp)
+	if err != nil {
+		return tmp, nil, err
+	}
+	return tmp, label, nil
+}
+
 func (p *Poi) parseLabel(tmp map[string]string) (*parsedLabel, error) {
-	u, ok := tmp["uri"]
+	var timestamp time.Time
+	if p.needTime {
+		t, ok := tmp[p.TimeLabel]
+		if !ok {
+			return nil, errors.Errorf("Could not found time label %q", p.TimeLabel)
+		}
+		var err error
+		if timestamp, err = p.parseTime(t); err != nil {
+			return nil, skip
+		}
+		if !p.inTimeRange(timestamp) {
+			return nil, filtered
+		}
+	} else if p.useTime {
+		// The time label is optional
+		if t, ok := tmp[p.TimeLabel]; ok {
+			timestamp, _ = p.parseTime(t)
+		}
+	}
+
+	u, ok := tmp[p.URILabel]
 	if !ok {
-		return nil, errors.New("Could not found uri label")
+		return nil, errors.Errorf("Could not found uri label %q", p.URILabel)
 	}
 	parsed, err := url.Parse(u)
 	if err != nil {
 		return nil, skip
 	}
-	uri := parsed.Path
-
-	// Added to count number of uri
-	if _, ok := p.uriMap[uri]; !ok {
-		p.uriMap[uri] = true
-	}
+	uri := p.groupURI(parsed.Path)
 
 	statusCode, ok := tmp[p.StatusLabel]
 	if !ok {
-		return nil, errors.New("Could not found status label")
+		return nil, errors.Errorf("Could not found status label %q", p.StatusLabel)
 	}
-
-	apptime, ok := tmp[p.ApptimeLabel]
-	if !ok {
-		return nil, errors.New("Could not found apptime label")
+	if statusCode == "" {
+		return nil, skip // Like null in jsonl
 	}
 
+	// Fallback to reqtime if apptime label is not found or not a number.
+	// Lines without both are ignored, and warned by labelCheck.
+	apptime := tmp[p.ApptimeLabel]
 	resTime, err := strconv.ParseFloat(apptime, 64)
 	if err != nil {
-		var reqTime float64
-		req, ok := tmp[p.ReqtimeLabel]
-		if !ok {
-			return nil, errors.New("Could not found reqtime label")
-		}
-		reqTime, err = strconv.ParseFloat(req, 64)
-		if err != nil {
-			return nil, skip
+		resTime = 0 // The format may have no response time
+		if !p.noResTime {
+			req, ok := tmp[p.ReqtimeLabel]
+			if !ok {
+				return nil, skip
+			}
+			if resTime, err = strconv.ParseFloat(req, 64); err != nil {
+				return nil, skip
+			}
 		}
-		resTime = reqTime
 	}
 
 	size, ok := tmp[p.SizeLabel]
 	if !ok {
-		return nil, errors.New("Could not found size label")
+		return nil, errors.Errorf("Could not found size label %q", p.SizeLabel)
 	}
 	bodySize, err := strconv.ParseFloat(size, 64)
 	if err != nil {
@@ -347,100 +650,34 @@ func (p *Poi) parseLabel(tmp map[string]string) (*parsedLabel, error) {
 
 	method, ok := tmp[p.MethodLabel]
 	if !ok {
-		return nil, errors.New("Could not found method label")
+		return nil, errors.Errorf("Could not found method label %q", p.MethodLabel)
 	}
 	return &parsedLabel{
+		keys:       p.groupKeys(tmp, uri, method, statusCode),
 		uri:        uri,
+		rawURI:     parsed.Path,
 		method:     method,
 		statusCode: statusCode,
 		resTime:    resTime,
 		bodySize:   bodySize,
+		timestamp:  timestamp,
 	}, nil
 }
 
-func (p *Poi) makeResult(l *parsedLabel) {
-	key := l.uri + ":" + l.method
-	dict := dataMap.get(key)
-	if dict == nil {
-		dataMap.set(key, &tableData{
-			count:         1,
-			minTime:       l.resTime,
-			maxTime:       l.resTime,
-			avgTime:       l.resTime,
-			p10:           l.resTime,
-			p50:           l.resTime,
-			p90:           l.resTime,
-			p95:           l.resTime,
-			p99:           l.resTime,
-			minBody:       l.bodySize,
-			maxBody:       l.bodySize,
-			avgBody:       l.bodySize,
-			responseTimes: []float64{l.resTime},
-		})
-		dict = dataMap.get(key)
-	} else {
-		dict.count++
-		// Current response time
-		dict.responseTimes = append(dict.responseTimes, l.resTime)
-		sort.Float64s(dict.responseTimes)
-
-		// Get the index for percentile
-		p10idx := getPercentileIdx(dict.count, 10)
-		p50idx := getPercentileIdx(dict.count, 50)
-		p90idx := getPercentileIdx(dict.count, 90)
-		p95idx := getPercentileIdx(dict.count, 95)
-		p99idx := getPercentileIdx(dict.count, 99)
-
-		// Get percentiles
-		dict.p10 = dict.responseTimes[p10idx]
-		dict.p50 = dict.responseTimes[p50idx]
-		dict.p90 = dict.responseTimes[p90idx]
-		dict.p95 = dict.responseTimes[p95idx]
-		dict.p99 = dict.responseTimes[p99idx]
-
-		if dict.maxTime < l.resTime {
-			dict.maxTime = l.resTime
-		}
-		if dict.minTime > l.resTime || dict.minTime == 0 {
-			dict.minTime = l.resTime
-		}
-		now := float64(dict.count)
-		before := now - 1.0
-
-		// newAvg = (oldAvg * lenOfoldAvg + newVal) / lenOfnewAvg
-		dict.avgTime = (dict.avgTime*before + l.resTime) / now
-
-		// standard deviation
-		// stdev = √[(1 / n - 1) * {Σ(xi - avg) ^ 2}]
-		stdev := float64(0)
-		for _, t := range dict.responseTimes {
-			diff := t - dict.avgTime
-			stdev += diff * diff
-		}
-		dict.stdev = math.Sqrt(stdev / before)
-
-		// Current response body size
-		if dict.maxBody < l.bodySize {
-			dict.maxBody = l.bodySize
-		}
-		if dict.minBody > l.bodySize || dict.minBody == 0 {
-			dict.minBody = l.bodySize
-		}
-		// newAvg = (oldAvg * lenOfoldAvg + newVal) / lenOfnewAvg
-		dict.avgBody = (dict.avgBody*before + l.bodySize) / now
+// makeResult adds l into d, and returns the data of its key
+func (p *Poi) makeResult(d *dict, l *parsedLabel) *tableData {
+	key := makeKey(l.keys)
+	val := d.get(key)
+	if val == nil {
+		val = newTableData(l, p.Precise)
+		d.set(key, val)
 	}
-
-	// Current status code
-	switch l.statusCode[0] {
-	case '2':
-		dict.code2xx++
-	case '3':
-		dict.code3xx++
-	case '4':
-		dict.code4xx++
-	case '5':
-		dict.code5xx++
+	val.add(l)
+	d.addURI(l.uri, l.rawURI)
+	if !l.timestamp.IsZero() {
+		d.addTime(l.timestamp)
 	}
+	return val
 }
 
 func parseLTSV(text string) map[string]string {
@@ -469,14 +706,6 @@ func tailConfig() tail.Config {
 	}
 }
 
-func getPercentileIdx(len int, n int) int {
-	idx := (len * n / 100) - 1
-	if idx < 0 {
-		return 0
-	}
-	return idx
-}
-
 func (p *Poi) setLineData(val map[string]string) {
 	l := len(val)
 	keys := make([]string, 0, l)
@@ -0,0 +1,86 @@
+package poi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLine(t *testing.T) {
+	p := &Poi{Options: Options{GroupBy: "method,status,uri"}}
+	if err := p.makeLabel(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.makeGroupBy(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		format   lineParser
+		line     string
+		want     []string // keys of the label
+		wantSkip bool
+		wantErr  bool
+	}{
+		{
+			name:   "jsonl",
+			format: parseJSONL,
+			line:   `{"method": "GET", "uri": "/foo?a=1", "status": 200, "size": 12, "apptime": 0.1}`,
+			want:   []string{"GET", "200", "/foo"},
+		},
+		{
+			name:     "jsonl status is null",
+			format:   parseJSONL,
+			line:     `{"method": "GET", "uri": "/foo", "status": null, "size": 12, "apptime": 0.1}`,
+			wantSkip: true,
+		},
+		{
+			name:     "jsonl status is empty",
+			format:   parseJSONL,
+			line:     `{"method": "GET", "uri": "/foo", "status": "", "size": 12, "apptime": 0.1}`,
+			wantSkip: true,
+		},
+		{
+			name:    "jsonl status is missing",
+			format:  parseJSONL,
+			line:    `{"method": "GET", "uri": "/foo", "size": 12, "apptime": 0.1}`,
+			wantErr: true,
+		},
+		{
+			name:     "ltsv status is empty",
+			format:   func(text string) (map[string]string, error) { return parseLTSV(text), nil },
+			line:     "method:GET\turi:/foo\tstatus:\tsize:12\tapptime:0.1",
+			wantSkip: true,
+		},
+		{
+			name:   "ltsv apptime falls back to reqtime",
+			format: func(text string) (map[string]string, error) { return parseLTSV(text), nil },
+			line:   "method:POST\turi:/bar\tstatus:500\tsize:12\tapptime:-\trequest_time:0.2",
+			want:   []string{"POST", "500", "/bar"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.parse = tt.format
+			_, label, err := p.parseLine(tt.line)
+			if tt.wantSkip {
+				if _, ok := err.(skipErr); !ok {
+					t.Fatalf("want skip error, but got %v, %v", label, err)
+				}
+				return
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("want error, but got %v", label)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(label.keys, tt.want) {
+				t.Errorf("\n got: %v\nwant: %v", label.keys, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package poi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Code-Hex/exit"
+	"github.com/pkg/errors"
+)
+
+const metricsPath = "/metrics"
+
+// serveMetrics starts the http server of --listen which exposes
+// dataMap in the Prometheus text format
+func (p *Poi) serveMetrics() (stop func(), err error) {
+	ln, err := net.Listen("tcp", p.Listen)
+	if err != nil {
+		return nil, exit.MakeIOErr(errors.Wrap(err, "Failed to listen"))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(metricsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		p.writeMetrics(w)
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	return func() { srv.Close() }, nil
+}
+
+// writeMetrics writes metrics of each key like
+//
+//	poi_requests_total{method="GET",uri="/foo"} 11
+//	poi_responses_total{method="GET",uri="/foo",class="2xx"} 11
+//	poi_response_size_bytes_total{method="GET",uri="/foo"} 396
+//	poi_response_time_seconds{method="GET",uri="/foo",quantile="0.99"} 0.2
+//	poi_response_time_seconds_sum{method="GET",uri="/foo"} 1.65
+//	poi_response_time_seconds_count{method="GET",uri="/foo"} 11
+//
+// Labels are --group-by labels.
+func (p *Poi) writeMetrics(w io.Writer) {
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	mu.RLock()
+	read, ignored, filtered := p.row, p.ignored, p.filtered
+	mu.RUnlock()
+	writeMetric(bw, "poi_read_lines_total", "counter", "Number of read lines.", read)
+	writeMetric(bw, "poi_ignored_lines_total", "counter", "Number of lines which could not be parsed.", ignored)
+	writeMetric(bw, "poi_filtered_lines_total", "counter", "Number of lines filtered out.", filtered)
+
+	// The first ones of calculated percentiles, see makePercentiles
+	percentiles := p.percentiles
+	if len(percentiles) == 0 {
+		percentiles = defaultPercentiles
+	}
+
+	dataMu.RLock()
+	defer dataMu.RUnlock()
+	keys := dataMap.allKeys()
+	vals := make([]*tableData, len(keys))
+	labels := make([]string, len(keys))
+	for i, key := range keys {
+		vals[i] = dataMap.get(key)
+		labels[i] = p.metricLabels(vals[i].keys)
+	}
+
+	fmt.Fprintln(bw, "# HELP poi_requests_total Number of requests.")
+	fmt.Fprintln(bw, "# TYPE poi_requests_total counter")
+	for i, val := range vals {
+		fmt.Fprintf(bw, "poi_requests_total{%s} %d\n", labels[i], val.count)
+	}
+
+	fmt.Fprintln(bw, "# HELP poi_responses_total Number of responses by status code class.")
+	fmt.Fprintln(bw, "# TYPE poi_responses_total counter")
+	for i, val := range vals {
+		for _, c := range []struct {
+			class string
+			count int
+		}{{"2xx", val.code2xx}, {"3xx", val.code3xx}, {"4xx", val.code4xx}, {"5xx", val.code5xx}} {
+			fmt.Fprintf(bw, "poi_responses_total{%s,class=%q} %d\n", labels[i], c.class, c.count)
+		}
+	}
+
+	fmt.Fprintln(bw, "# HELP poi_response_size_bytes_total Sum of response body sizes.")
+	fmt.Fprintln(bw, "# TYPE poi_response_size_bytes_total counter")
+	for i, val := range vals {
+		fmt.Fprintf(bw, "poi_response_size_bytes_total{%s} %s\n", labels[i], formatFull(val.sumBody))
+	}
+
+	fmt.Fprintln(bw, "# HELP poi_response_time_seconds Response times.")
+	fmt.Fprintln(bw, "# TYPE poi_response_time_seconds summary")
+	for i, val := range vals {
+		for j, n := range percentiles {
+			q := strconv.FormatFloat(n/100, 'f', -1, 64)
+			fmt.Fprintf(bw, "poi_response_time_seconds{%s,quantile=%q} %s\n", labels[i], q, formatFull(val.percentiles[j]))
+		}
+		fmt.Fprintf(bw, "poi_response_time_seconds_sum{%s} %s\n", labels[i], formatFull(val.sumTime))
+		fmt.Fprintf(bw, "poi_response_time_seconds_count{%s} %d\n", labels[i], val.count)
+	}
+}
+
+func writeMetric(w io.Writer, name, typ, help string, v int) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, typ, name, v)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricLabels returns labels like `method="GET",uri="/foo"` of keys
+func (p *Poi) metricLabels(keys []string) string {
+	pairs := make([]string, len(keys))
+	for i, v := range keys {
+		pairs[i] = metricLabelName(p.groupBy[i]) + `="` + labelValueEscaper.Replace(v) + `"`
+	}
+	return strings.Join(pairs, ",")
+}
+
+// metricLabelName replaces characters which can not be in label names with "_"
+func metricLabelName(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9' {
+			continue
+		}
+		b[i] = '_'
+	}
+	return string(b)
+}
@@ -0,0 +1,69 @@
+package poi
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	p := &Poi{Options: Options{GroupBy: "method,uri", Listen: ":9100"}}
+	if err := p.makeGroupBy(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.makePercentiles(); err != nil {
+		t.Fatal(err)
+	}
+	dataMap = newDict()
+	add := func(uri string, resTime float64) {
+		l := &parsedLabel{
+			keys:       []string{"GET", uri},
+			uri:        uri,
+			method:     "GET",
+			statusCode: "200",
+			resTime:    resTime,
+			bodySize:   10,
+		}
+		dataMu.Lock()
+		p.makeResult(dataMap, l).finalize(p.calcPercentiles)
+		dataMu.Unlock()
+	}
+	for i := 1; i <= 100; i++ {
+		add("/a", float64(i)/100)
+	}
+
+	var buf bytes.Buffer
+	p.writeMetrics(&buf)
+	for _, want := range []string{
+		`poi_requests_total{method="GET",uri="/a"} 100`,
+		// The defaults without --percentiles
+		`poi_response_time_seconds{method="GET",uri="/a",quantile="0.1"} `,
+		`poi_response_time_seconds{method="GET",uri="/a",quantile="0.99"} `,
+		`poi_response_time_seconds_count{method="GET",uri="/a"} 100`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("%q is not found in\n%s", want, buf.String())
+		}
+	}
+
+	// Scrapes are concurrent with each other and the aggregator
+	for i := 0; i < 100; i++ {
+		add("/b", float64(i)/100)
+	}
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				p.writeMetrics(ioutil.Discard)
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		add("/c", float64(i)/100)
+	}
+	wg.Wait()
+}
@@ -2,51 +2,105 @@ package poi
 
 import (
 	"fmt"
-	"os"
-	"strings"
+	"io"
+	"math"
 
 	termbox "github.com/nsf/termbox-go"
 	"github.com/olekukonko/tablewriter"
 )
 
-func (p *Poi) renderTable() {
-	table := tablewriter.NewWriter(os.Stdout)
+func (p *Poi) renderTable(w io.Writer) {
+	table := tablewriter.NewWriter(w)
 	table.SetHeader(p.header)
 
-	data := make([][]string, len(dataMap.keys))
+	data := make([][]string, 0, len(dataMap.keys))
 	for _, key := range dataMap.sortedKeys(p.Sortby) {
-		val := dataMap.get(key)
-		sep := strings.Split(key, ":")
-		uri, method := sep[0], sep[1]
-		tmp := []string{
-			fmt.Sprintf("%d", val.count),
-			fmt.Sprintf("%.3f", val.minTime),
-			fmt.Sprintf("%.3f", val.maxTime),
-			fmt.Sprintf("%.3f", val.avgTime),
-			fmt.Sprintf("%.3f", val.stdev),
-		}
+		data = append(data, p.cells(dataMap.get(key), false))
+	}
+	table.AppendBulk(data)
+	table.Render()
+}
 
-		if p.Expand {
-			tmp = append(tmp,
-				fmt.Sprintf("%.3f", val.p10),
-				fmt.Sprintf("%.3f", val.p50),
-				fmt.Sprintf("%.3f", val.p90),
-				fmt.Sprintf("%.3f", val.p95),
-				fmt.Sprintf("%.3f", val.p99),
-			)
-		}
+func (p *Poi) renderFiles(w io.Writer) {
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"FILE", "READ", "IGNORE", "FILTER"})
+	for _, f := range p.files {
+		table.Append([]string{
+			f.name,
+			fmt.Sprintf("%d", f.read),
+			fmt.Sprintf("%d", f.ignored),
+			fmt.Sprintf("%d", f.filtered),
+		})
+	}
+	table.Render()
+}
 
-		tmp = append(tmp,
-			fmt.Sprintf("%.2f", val.minBody),
-			fmt.Sprintf("%.2f", val.maxBody),
-			fmt.Sprintf("%.2f", val.avgBody),
-			method, uri,
-		)
+// cells returns strings to render for each column of p.header.
+// Times and sizes are rounded like "0.123" and "12.00" unless full is true.
+func (p *Poi) cells(val *tableData, full bool) []string {
+	ftime := func(v float64) string { return fmt.Sprintf("%.3f", v) } // Strlen is 5 <- "0.000"
+	fbody := func(v float64) string { return fmt.Sprintf("%.2f", v) } // Strlen is 5 <- "00.00"
+	if full {
+		ftime = formatFull
+		fbody = formatFull
+	}
+	frate := func(v float64) string {
+		if math.IsNaN(v) {
+			return "-" // The time label is not found
+		}
+		return fbody(v)
+	}
 
-		data = append(data, tmp)
+	cells := make([]string, len(p.header))
+	for i, h := range p.header {
+		if n := i - p.percentileIdx; 0 <= n && n < len(p.percentiles) {
+			cells[i] = ftime(val.percentiles[n])
+			continue
+		}
+		if n := i - p.keyIdx; 0 <= n {
+			cells[i] = val.keys[n]
+			continue
+		}
+		switch h {
+		case "COUNT":
+			cells[i] = fmt.Sprintf("%d", val.count)
+		case "MIN":
+			cells[i] = ftime(val.minTime)
+		case "MAX":
+			cells[i] = ftime(val.maxTime)
+		case "SUM":
+			cells[i] = ftime(val.sumTime)
+		case "%TIME":
+			cells[i] = fbody(val.timeShare)
+		case "AVG":
+			cells[i] = ftime(val.avgTime)
+		case "STDEV":
+			cells[i] = ftime(val.stdev)
+		case "BODYMIN":
+			cells[i] = fbody(val.minBody)
+		case "BODYMAX":
+			cells[i] = fbody(val.maxBody)
+		case "BODYAVG":
+			cells[i] = fbody(val.avgBody)
+		case "2XX":
+			cells[i] = fmt.Sprintf("%d", val.code2xx)
+		case "3XX":
+			cells[i] = fmt.Sprintf("%d", val.code3xx)
+		case "4XX":
+			cells[i] = fmt.Sprintf("%d", val.code4xx)
+		case "5XX":
+			cells[i] = fmt.Sprintf("%d", val.code5xx)
+		case "RPS":
+			cells[i] = frate(val.rps)
+		case "BPS":
+			cells[i] = frate(val.bps)
+		case "ERR%":
+			cells[i] = fbody(val.errorRate())
+		case "URIS":
+			cells[i] = fmt.Sprintf("%d", p.countURIs(val.keys[p.uriKeyIdx]))
+		}
 	}
-	table.AppendBulk(data)
-	table.Render()
+	return cells
 }
 
 func (p *Poi) renderAll() {
@@ -58,6 +112,9 @@ func (p *Poi) renderAll() {
 
 func (p *Poi) renderBottomPane() {
 	p.clearPane(false)
+	if len(p.lineData) == 0 {
+		return // No lines are read yet
+	}
 
 	posMiddle := p.height / 2
 
@@ -122,123 +179,66 @@ func (p *Poi) renderMiddleLine() {
 
 func (p *Poi) renderTopPane() {
 	p.clearPane(true)
+	d := p.topDict()
 
-	read := 0 // Number of rows could be read
-
-	// To adjust width
-	countStrMaxLen := 0
-	minBodyStrMaxLen := 6 // "BODYMIN" length is 6
-	maxBodyStrMaxLen := 6 // "BODYMAX" length is 6
-	avgBodyStrMaxLen := 6 // "BODYAVG" length is 6
-
-	for _, key := range dataMap.keys {
-		val := dataMap.get(key)
-		read += val.count // Added number of rows
-
-		countStr := fmt.Sprintf("%d", val.count)
-		if l := len(countStr); l > countStrMaxLen {
-			countStrMaxLen = l
-		}
-
-		minBStr := fmt.Sprintf("%.2f", val.minBody)
-		if l := len(minBStr); l > minBodyStrMaxLen {
-			minBodyStrMaxLen = l
-		}
+	// Values of dataMap are updated by the aggregator while rendering
+	dataMu.Lock()
+	defer dataMu.Unlock()
+	d.updateRatios()
 
-		maxBStr := fmt.Sprintf("%.2f", val.maxBody)
-		if l := len(maxBStr); l > maxBodyStrMaxLen {
-			maxBodyStrMaxLen = l
-		}
+	// To adjust width, each column has at least the width of its header
+	widths := make([]int, len(p.header))
+	for i, h := range p.header {
+		widths[i] = len(h)
+	}
 
-		avgBStr := fmt.Sprintf("%.2f", val.avgBody)
-		if l := len(avgBStr); l > avgBodyStrMaxLen {
-			avgBodyStrMaxLen = l
+	for _, key := range d.keys {
+		for i, c := range p.cells(d.get(key), false) {
+			if l := len(c); l > widths[i] {
+				widths[i] = l
+			}
 		}
 	}
 
-	// Number of rows could not be read
-	ignore := p.row - read
-
-	renderStr(0, 0, fmt.Sprintf("Total URI: %d", len(p.uriMap)))
-	renderStr(0, 1, fmt.Sprintf("Read lines: %d, Ignore lines: %d", p.row, ignore))
+	mu.RLock()
+	switch {
+	case p.window == nil:
+		renderStr(0, 0, fmt.Sprintf("Total URI: %d", dataMap.totalURIs()))
+	case p.showWindow:
+		renderStr(0, 0, fmt.Sprintf("Total URI: %d, Showing: last %s (press w to show since start)", dataMap.totalURIs(), p.Window))
+	default:
+		renderStr(0, 0, fmt.Sprintf("Total URI: %d, Showing: since start (press w to show last %s)", dataMap.totalURIs(), p.Window))
+	}
+	if p.filter != nil || p.needTime {
+		renderStr(0, 1, fmt.Sprintf("Read lines: %d, Ignore lines: %d, Filtered lines: %d", p.row, p.ignored, p.filtered))
+	} else {
+		renderStr(0, 1, fmt.Sprintf("Read lines: %d, Ignore lines: %d", p.row, p.ignored))
+	}
+	mu.RUnlock()
 
 	// Get width to draw data
 	for i, h := range p.header {
-		switch h {
-		case "COUNT":
-			var base int
-			if countStrMaxLen > 5 {
-				base = countStrMaxLen
-			} else {
-				base = 5
-			}
-			p.posXlist[1] = base + 2 // for "MIN"
-			renderStr(0, p.headerPosY, p.header[0])
-		case "MIN":
-			renderStr(p.posXlist[1], p.headerPosY, p.header[1])
-		case "MAX", "AVG", "STDEV":
-			p.posXlist[i] = p.posXlist[i-1] + 5 + 2
-			renderStr(p.posXlist[i], p.headerPosY, p.header[i])
-		case "P10", "P50", "P90", "P95", "P99":
-			p.posXlist[i] = p.posXlist[i-1] + 5 + 2
-			renderStr(p.posXlist[i], p.headerPosY, p.header[i])
-		case "BODYMIN":
-			p.posXlist[i] = p.posXlist[i-1] + 5 + 2
-			renderStr(p.posXlist[i], p.headerPosY, p.header[i])
-		case "BODYMAX":
-			p.posXlist[i] = p.posXlist[i-1] + minBodyStrMaxLen + 2
-			renderStr(p.posXlist[i], p.headerPosY, p.header[i])
-		case "BODYAVG":
-			p.posXlist[i] = p.posXlist[i-1] + avgBodyStrMaxLen + 2
-			renderStr(p.posXlist[i], p.headerPosY, p.header[i])
-		case "METHOD":
-			p.posXlist[i] = p.posXlist[i-1] + avgBodyStrMaxLen + 2
-			renderStr(p.posXlist[i], p.headerPosY, p.header[i])
-		case "URI":
-			p.posXlist[i] = p.posXlist[i-1] + 6 + 2
-			renderStr(p.posXlist[i], p.headerPosY, p.header[i])
+		if i > 0 {
+			p.posXlist[i] = p.posXlist[i-1] + widths[i-1] + 2
 		}
+		renderStr(p.posXlist[i], p.headerPosY, h)
 	}
 
 	hhalf := p.height / 2
 	// 4 is lines + space lines + header line
-	if semihalf := (hhalf - 1) - 4; semihalf < len(dataMap.keys) {
-		dataMap.setRow(semihalf)
+	if semihalf := (hhalf - 1) - 4; semihalf < len(d.keys) {
+		d.setRow(semihalf)
 	} else {
-		dataMap.resetRangeInfo()
+		d.resetRangeInfo()
 	}
 	// Rendering main data
-	for i, key := range dataMap.sortedKeys(p.Sortby) {
-		val := dataMap.get(key)
-		sep := strings.Split(key, ":")
-		uri, method := sep[0], sep[1]
-
+	for i, key := range d.sortedKeys(p.Sortby) {
 		posY := (p.headerPosY + 1) + i
 
 		p.clearLine(posY)
 
-		renderStr(p.posXlist[0], posY, fmt.Sprintf("%d", val.count))
-		renderStr(p.posXlist[1], posY, fmt.Sprintf("%.3f", val.minTime)) // Strlen is 5 <- "0.000"
-		renderStr(p.posXlist[2], posY, fmt.Sprintf("%.3f", val.maxTime)) // Strlen is 5 <- "0.000"
-		renderStr(p.posXlist[3], posY, fmt.Sprintf("%.3f", val.avgTime)) // Strlen is 5 <- "0.000"
-		renderStr(p.posXlist[4], posY, fmt.Sprintf("%.3f", val.stdev))   // Strlen is 5 <- "0.000"
-		if p.Expand {
-			renderStr(p.posXlist[5], posY, fmt.Sprintf("%.3f", val.p10))      // Strlen is 5 <- "0.000"
-			renderStr(p.posXlist[6], posY, fmt.Sprintf("%.3f", val.p50))      // Strlen is 5 <- "0.000"
-			renderStr(p.posXlist[7], posY, fmt.Sprintf("%.3f", val.p90))      // Strlen is 5 <- "0.000"
-			renderStr(p.posXlist[8], posY, fmt.Sprintf("%.3f", val.p95))      // Strlen is 5 <- "0.000"
-			renderStr(p.posXlist[9], posY, fmt.Sprintf("%.3f", val.p99))      // Strlen is 5 <- "0.000"
-			renderStr(p.posXlist[10], posY, fmt.Sprintf("%.2f", val.minBody)) // Strlen is 5 <- "00.00"
-			renderStr(p.posXlist[11], posY, fmt.Sprintf("%.2f", val.maxBody)) // Strlen is 5 <- "00.00"
-			renderStr(p.posXlist[12], posY, fmt.Sprintf("%.2f", val.avgBody)) // Strlen is 5 <- "00.00"
-			renderStr(p.posXlist[13], posY, method)                           // "METHOD" len is 6"
-			renderStr(p.posXlist[14], posY, uri)
-		} else {
-			renderStr(p.posXlist[5], posY, fmt.Sprintf("%.2f", val.minBody)) // Strlen is 5 <- "00.00"
-			renderStr(p.posXlist[6], posY, fmt.Sprintf("%.2f", val.maxBody)) // Strlen is 5 <- "00.00"
-			renderStr(p.posXlist[7], posY, fmt.Sprintf("%.2f", val.avgBody)) // Strlen is 5 <- "00.00"
-			renderStr(p.posXlist[8], posY, method)                           // "METHOD" length is 6"
-			renderStr(p.posXlist[9], posY, uri)
+		for j, c := range p.cells(d.get(key), false) {
+			renderStr(p.posXlist[j], posY, c)
 		}
 	}
 }
@@ -10,7 +10,7 @@ import (
 
 const (
 	version = "0.0.1"
-	msg     = name + " v" + version + ", Yet another access log profiler for ltsv"
+	msg     = name + " v" + version + ", Yet another access log profiler"
 	name    = "poi"
 )
 
@@ -52,16 +52,52 @@ func (p *Poi) prepare() ([]string, error) {
 	if err := p.makeLabel(); err != nil {
 		return nil, err
 	}
+	if err := p.makeGroups(); err != nil {
+		return nil, err
+	}
+	if err := p.makeGroupBy(); err != nil {
+		return nil, err
+	}
+	if err := p.makeParser(); err != nil {
+		return nil, err
+	}
+	if err := p.makeFilter(); err != nil {
+		return nil, err
+	}
+	if err := p.makeTimeRange(); err != nil {
+		return nil, err
+	}
+	if p.CheckLines > 0 {
+		p.makeLabelCheck()
+	}
+	if p.MaxLineLen < 1 {
+		return nil, exit.MakeDataErr(errors.Errorf("--max-line-length must be 1 or more: %d", p.MaxLineLen))
+	}
+	if err := p.makeFiles(); err != nil {
+		return nil, err
+	}
+	if err := p.makePercentiles(); err != nil {
+		return nil, err
+	}
+	if err := p.makeSortBy(); err != nil {
+		return nil, err
+	}
+	if err := p.makeOutput(); err != nil {
+		return nil, err
+	}
+	if err := p.makeWindow(); err != nil {
+		return nil, err
+	}
 	return args, nil
 }
 
 func (p *Poi) makeLabel() error {
 	if p.LabelAs != "" {
-		label, err := loadYAML(p.LabelAs)
+		conf, err := loadYAML(p.LabelAs)
 		if err != nil {
 			return exit.MakeSoftWare(err)
 		}
-		p.Label = label
+		p.Config = conf
 	}
 
 	if p.ApptimeLabel == "" {
@@ -82,6 +118,12 @@ func (p *Poi) makeLabel() error {
 	if p.URILabel == "" {
 		p.URILabel = "uri"
 	}
+	if p.TimeLabel == "" {
+		p.TimeLabel = "time"
+	}
+	if p.HostLabel == "" {
+		p.HostLabel = "host"
+	}
 
 	return nil
 }
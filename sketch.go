@@ -0,0 +1,196 @@
+package poi
+
+import (
+	"math"
+	"sort"
+)
+
+// quantileSketch holds response times to get percentiles
+type quantileSketch interface {
+	add(v float64)
+	merge(other quantileSketch)
+	// quantile returns the value at the percentile n (0 < n <= 100)
+	quantile(n float64) float64
+	// binCounts returns the number of values in each range of edges.
+	// Values out of edges are counted in the first or the last range.
+	binCounts(edges []float64) []int
+}
+
+func newSketch(precise bool) quantileSketch {
+	if precise {
+		return &samples{}
+	}
+	return newHistogram()
+}
+
+// samples keeps all values to get exact percentiles
+type samples struct {
+	values []float64
+	sorted bool
+}
+
+func (s *samples) add(v float64) {
+	s.values = append(s.values, v)
+	s.sorted = false
+}
+
+func (s *samples) merge(other quantileSketch) {
+	s.values = append(s.values, other.(*samples).values...)
+	s.sorted = false
+}
+
+func (s *samples) sort() {
+	if !s.sorted {
+		sort.Float64s(s.values)
+		s.sorted = true
+	}
+}
+
+func (s *samples) quantile(n float64) float64 {
+	s.sort()
+	return s.values[getPercentileIdx(len(s.values), n)]
+}
+
+func (s *samples) binCounts(edges []float64) []int {
+	counts := make([]int, len(edges)-1)
+	for _, v := range s.values {
+		counts[binIndex(edges, v)]++
+	}
+	return counts
+}
+
+// stats returns the sum, the mean and the standard deviation calculated
+// from sorted values, so that it does not depend on the order of values.
+func (s *samples) stats() (sum, mean, stdev float64) {
+	s.sort()
+	for _, v := range s.values {
+		sum += v
+	}
+	n := float64(len(s.values))
+	mean = sum / n
+	if len(s.values) < 2 {
+		return sum, mean, 0
+	}
+	// stdev = √[(1 / n - 1) * {Σ(xi - avg) ^ 2}]
+	for _, v := range s.values {
+		diff := v - mean
+		stdev += diff * diff
+	}
+	return sum, mean, math.Sqrt(stdev / (n - 1))
+}
+
+const (
+	// Relative error of values in histogram
+	histogramPrecision = 0.01
+	// Values less than this are counted as zero
+	histogramMinValue = 1e-9
+)
+
+var logGamma = math.Log((1 + histogramPrecision) / (1 - histogramPrecision))
+
+// histogram is a HDR-style histogram which has log-scaled buckets.
+// It uses constant memory for any number of values, and merging
+// histograms is exact because it just adds counts of buckets.
+type histogram struct {
+	counts   map[int]int
+	indices  []int // sorted keys of counts
+	zero     int
+	count    int
+	min, max float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{
+		counts: make(map[int]int),
+		min:    math.Inf(1),
+		max:    math.Inf(-1),
+	}
+}
+
+func (h *histogram) add(v float64) {
+	h.count++
+	h.min = math.Min(h.min, v)
+	h.max = math.Max(h.max, v)
+	if v < histogramMinValue {
+		h.zero++
+		return
+	}
+	h.addBucket(int(math.Ceil(math.Log(v)/logGamma)), 1)
+}
+
+func (h *histogram) addBucket(idx, count int) {
+	if _, ok := h.counts[idx]; !ok {
+		h.indices = nil // to sort again
+	}
+	h.counts[idx] += count
+}
+
+func (h *histogram) merge(other quantileSketch) {
+	o := other.(*histogram)
+	h.count += o.count
+	h.zero += o.zero
+	h.min = math.Min(h.min, o.min)
+	h.max = math.Max(h.max, o.max)
+	for idx, c := range o.counts {
+		h.addBucket(idx, c)
+	}
+}
+
+func (h *histogram) quantile(n float64) float64 {
+	rank := getPercentileIdx(h.count, n)
+	if rank < h.zero {
+		return h.min
+	}
+	if h.indices == nil {
+		h.indices = make([]int, 0, len(h.counts))
+		for idx := range h.counts {
+			h.indices = append(h.indices, idx)
+		}
+		sort.Ints(h.indices)
+	}
+	seen := h.zero
+	for _, idx := range h.indices {
+		if seen += h.counts[idx]; rank < seen {
+			// The middle of the bucket
+			v := 2 * math.Exp(float64(idx)*logGamma) / (1 + math.Exp(logGamma))
+			return math.Max(h.min, math.Min(h.max, v))
+		}
+	}
+	return h.max
+}
+
+func (h *histogram) binCounts(edges []float64) []int {
+	counts := make([]int, len(edges)-1)
+	counts[binIndex(edges, h.min)] += h.zero
+	for idx, c := range h.counts {
+		v := 2 * math.Exp(float64(idx)*logGamma) / (1 + math.Exp(logGamma))
+		v = math.Max(h.min, math.Min(h.max, v))
+		counts[binIndex(edges, v)] += c
+	}
+	return counts
+}
+
+// binIndex returns the index of the range of edges which v is in
+func binIndex(edges []float64, v float64) int {
+	i := sort.SearchFloat64s(edges, v)
+	if i < len(edges) && edges[i] == v {
+		i++ // edges[i] <= v < edges[i+1]
+	}
+	i--
+	if i < 0 {
+		return 0
+	}
+	if i > len(edges)-2 {
+		return len(edges) - 2
+	}
+	return i
+}
+
+// getPercentileIdx returns the index of the percentile n in sorted values of len
+func getPercentileIdx(len int, n float64) int {
+	idx := int(float64(len)*n/100) - 1
+	if idx < 0 {
+		return 0
+	}
+	return idx
+}
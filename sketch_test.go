@@ -0,0 +1,103 @@
+package poi
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// responseTimes returns n values by gen with a fixed seed
+func responseTimes(n int, gen func(r *rand.Rand) float64) []float64 {
+	r := rand.New(rand.NewSource(1))
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = gen(r)
+	}
+	return values
+}
+
+var distributions = []struct {
+	name string
+	gen  func(r *rand.Rand) float64
+}{
+	{"uniform", func(r *rand.Rand) float64 { return r.Float64() }},
+	{"exponential", func(r *rand.Rand) float64 { return r.ExpFloat64() * 0.1 }},
+	{"lognormal", func(r *rand.Rand) float64 { return math.Exp(r.NormFloat64() * 2) }},
+	{"constant", func(r *rand.Rand) float64 { return 0.123 }},
+	{"with zeros", func(r *rand.Rand) float64 {
+		if r.Intn(4) == 0 {
+			return 0
+		}
+		return r.Float64()
+	}},
+}
+
+var testPercentiles = []float64{0.1, 1, 10, 50, 90, 95, 99, 99.9, 100}
+
+func TestHistogramQuantile(t *testing.T) {
+	for _, tt := range distributions {
+		t.Run(tt.name, func(t *testing.T) {
+			h, s := newHistogram(), &samples{}
+			for _, v := range responseTimes(10000, tt.gen) {
+				h.add(v)
+				s.add(v)
+			}
+			for _, n := range testPercentiles {
+				got, want := h.quantile(n), s.quantile(n)
+				if math.Abs(got-want) > want*histogramPrecision {
+					t.Errorf("p%v: got %v, want %v within %v", n, got, want, histogramPrecision)
+				}
+			}
+		})
+	}
+}
+
+// chunks splits values into n chunks of different sizes
+func chunks(values []float64, n int) [][]float64 {
+	result := make([][]float64, 0, n)
+	for i := 0; i < n; i++ {
+		// Later chunks are larger
+		from, to := len(values)*i*i/(n*n), len(values)*(i+1)*(i+1)/(n*n)
+		result = append(result, values[from:to])
+	}
+	return result
+}
+
+func TestSketchMerge(t *testing.T) {
+	for _, precise := range []bool{false, true} {
+		for _, tt := range distributions {
+			values := responseTimes(10000, tt.gen)
+			serial := newSketch(precise)
+			for _, v := range values {
+				serial.add(v)
+			}
+			for _, n := range []int{1, 2, 7, 64} {
+				merged := newSketch(precise)
+				for _, c := range chunks(values, n) {
+					part := newSketch(precise)
+					for _, v := range c {
+						part.add(v)
+					}
+					merged.merge(part)
+				}
+				// Merging is exact for both of sketches
+				for _, p := range testPercentiles {
+					if got, want := merged.quantile(p), serial.quantile(p); got != want {
+						t.Errorf("precise=%v %s %d chunks p%v: got %v, want %v", precise, tt.name, n, p, got, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestSamplesStats(t *testing.T) {
+	s := &samples{}
+	for _, v := range []float64{4, 2, 5, 8, 6} {
+		s.add(v)
+	}
+	sum, mean, stdev := s.stats()
+	if sum != 25 || mean != 5 || math.Abs(stdev-math.Sqrt(5)) > 1e-12 {
+		t.Errorf("got %v, %v, %v, want 25, 5, %v", sum, mean, stdev, math.Sqrt(5))
+	}
+}
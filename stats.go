@@ -0,0 +1,169 @@
+package poi
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Code-Hex/exit"
+	"github.com/pkg/errors"
+)
+
+// Default percentiles to display with --expand
+var defaultPercentiles = []float64{10, 50, 90, 95, 99}
+
+// makePercentiles parses --percentiles, and registers them to sortMethod like "p99.9"
+func (p *Poi) makePercentiles() error {
+	p.percentiles = p.Percentiles // from yaml
+	if p.PercentileList != "" {
+		p.percentiles = p.percentiles[:0:0]
+		for _, s := range strings.Split(p.PercentileList, ",") {
+			n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err != nil {
+				return exit.MakeDataErr(errors.Wrapf(err, "invalid percentile %q", s))
+			}
+			p.percentiles = append(p.percentiles, n)
+		}
+	}
+	// json has the percentiles always
+	if len(p.percentiles) == 0 && (p.Expand || p.Output == "json") {
+		p.percentiles = defaultPercentiles
+	}
+
+	for i, n := range p.percentiles {
+		if n <= 0 || 100 < n {
+			return exit.MakeDataErr(errors.Errorf("percentile must be in (0, 100]: %v", n))
+		}
+		i := i
+		sortMethod[strings.ToLower(percentileHeader(n))] = func(t *tableData) float64 {
+			return t.percentiles[i]
+		}
+	}
+
+	// Metrics have the defaults if no percentiles are shown. They are
+	// calculated by the aggregator, not to update sketches on scraping.
+	p.calcPercentiles = p.percentiles
+	if len(p.calcPercentiles) == 0 && p.Listen != "" {
+		p.calcPercentiles = defaultPercentiles
+	}
+	return nil
+}
+
+// percentileHeader returns the header like "P99.9"
+func percentileHeader(n float64) string {
+	return "P" + strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+func newTableData(l *parsedLabel, precise bool) *tableData {
+	return &tableData{
+		keys:    l.keys,
+		minTime: l.resTime,
+		maxTime: l.resTime,
+		minBody: l.bodySize,
+		maxBody: l.bodySize,
+		times:   newSketch(precise),
+	}
+}
+
+// add adds a parsed line. Statistics are calculated by finalize.
+func (t *tableData) add(l *parsedLabel) {
+	t.count++
+	t.sumTime += l.resTime
+	t.times.add(l.resTime)
+
+	// Running mean and variance by Welford's method
+	delta := l.resTime - t.mean
+	t.mean += delta / float64(t.count)
+	t.m2 += delta * (l.resTime - t.mean)
+
+	if t.maxTime < l.resTime {
+		t.maxTime = l.resTime
+	}
+	if t.minTime > l.resTime {
+		t.minTime = l.resTime
+	}
+
+	// Current response body size
+	t.sumBody += l.bodySize
+	if t.maxBody < l.bodySize {
+		t.maxBody = l.bodySize
+	}
+	if t.minBody > l.bodySize {
+		t.minBody = l.bodySize
+	}
+
+	// Current status code
+	switch l.statusCode[0] {
+	case '2':
+		t.code2xx++
+	case '3':
+		t.code3xx++
+	case '4':
+		t.code4xx++
+	case '5':
+		t.code5xx++
+	}
+}
+
+// merge adds all data of other into t
+func (t *tableData) merge(other *tableData) {
+	// Combine mean and variance by Chan's method
+	n := float64(t.count + other.count)
+	delta := other.mean - t.mean
+	t.mean += delta * float64(other.count) / n
+	t.m2 += other.m2 + delta*delta*float64(t.count)*float64(other.count)/n
+
+	t.count += other.count
+	t.sumTime += other.sumTime
+	t.times.merge(other.times)
+	t.maxTime = math.Max(t.maxTime, other.maxTime)
+	t.minTime = math.Min(t.minTime, other.minTime)
+	t.sumBody += other.sumBody
+	t.maxBody = math.Max(t.maxBody, other.maxBody)
+	t.minBody = math.Min(t.minBody, other.minBody)
+	t.code2xx += other.code2xx
+	t.code3xx += other.code3xx
+	t.code4xx += other.code4xx
+	t.code5xx += other.code5xx
+}
+
+// clone returns a copy of t which does not share the sketch
+func (t *tableData) clone() *tableData {
+	_, precise := t.times.(*samples)
+	c := *t
+	c.percentiles = nil
+	c.times = newSketch(precise)
+	c.times.merge(t.times)
+	return &c
+}
+
+// errorRate returns the percentage of 5xx responses
+func (t *tableData) errorRate() float64 {
+	return float64(t.code5xx) / float64(t.count) * 100
+}
+
+// finalize calculates statistics to render
+func (t *tableData) finalize(percentiles []float64) {
+	n := float64(t.count)
+	t.avgBody = t.sumBody / n
+
+	if s, ok := t.times.(*samples); ok {
+		// Exact values with --precise
+		t.sumTime, t.avgTime, t.stdev = s.stats()
+	} else {
+		// standard deviation
+		// stdev = √[(1 / n - 1) * {Σ(xi - avg) ^ 2}]
+		t.avgTime, t.stdev = t.mean, 0
+		if t.count > 1 {
+			t.stdev = math.Sqrt(t.m2 / (n - 1))
+		}
+	}
+
+	// Get percentiles
+	if len(t.percentiles) != len(percentiles) {
+		t.percentiles = make([]float64, len(percentiles))
+	}
+	for i, n := range percentiles {
+		t.percentiles[i] = t.times.quantile(n)
+	}
+}
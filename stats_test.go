@@ -0,0 +1,92 @@
+package poi
+
+import (
+	"math"
+	"testing"
+)
+
+// makeTableData adds values which are from the offset of all lines
+func makeTableData(values []float64, offset int, precise bool) *tableData {
+	var t *tableData
+	for i, v := range values {
+		i += offset
+		l := &parsedLabel{
+			resTime:    v,
+			bodySize:   float64(i % 100),
+			statusCode: []string{"200", "302", "404", "503"}[i%4],
+		}
+		if t == nil {
+			t = newTableData(l, precise)
+		}
+		t.add(l)
+	}
+	return t
+}
+
+func closeTo(got, want float64) bool {
+	return math.Abs(got-want) <= 1e-9*math.Max(1, math.Abs(want))
+}
+
+// TestTableDataMerge checks that results merged from chunks are the same
+// as a single pass, which workers of normalmode rely on
+func TestTableDataMerge(t *testing.T) {
+	for _, precise := range []bool{false, true} {
+		for _, tt := range distributions {
+			values := responseTimes(10000, tt.gen)
+			serial := makeTableData(values, 0, precise)
+			serial.finalize(testPercentiles)
+
+			// Exact values to check the running mean and variance
+			exact := &samples{}
+			for _, v := range values {
+				exact.add(v)
+			}
+			_, mean, stdev := exact.stats()
+
+			for _, n := range []int{1, 2, 7, 64} {
+				var merged *tableData
+				offset := 0
+				for _, c := range chunks(values, n) {
+					part := makeTableData(c, offset, precise)
+					offset += len(c)
+					if merged == nil {
+						merged = part
+					} else {
+						merged.merge(part)
+					}
+				}
+				merged.finalize(testPercentiles)
+
+				if merged.count != serial.count {
+					t.Errorf("precise=%v %s %d chunks: count got %d, want %d", precise, tt.name, n, merged.count, serial.count)
+				}
+				if merged.minTime != serial.minTime || merged.maxTime != serial.maxTime {
+					t.Errorf("precise=%v %s %d chunks: min/max got %v/%v, want %v/%v", precise, tt.name, n,
+						merged.minTime, merged.maxTime, serial.minTime, serial.maxTime)
+				}
+				if merged.code2xx != serial.code2xx || merged.code3xx != serial.code3xx ||
+					merged.code4xx != serial.code4xx || merged.code5xx != serial.code5xx {
+					t.Errorf("precise=%v %s %d chunks: status codes are different", precise, tt.name, n)
+				}
+				for name, v := range map[string][2]float64{
+					"sum":         {merged.sumTime, serial.sumTime},
+					"avg":         {merged.avgTime, serial.avgTime},
+					"stdev":       {merged.stdev, serial.stdev},
+					"bodyavg":     {merged.avgBody, serial.avgBody},
+					"exact avg":   {merged.avgTime, mean},
+					"exact stdev": {merged.stdev, stdev},
+				} {
+					if !closeTo(v[0], v[1]) {
+						t.Errorf("precise=%v %s %d chunks: %s got %v, want %v", precise, tt.name, n, name, v[0], v[1])
+					}
+				}
+				for i, p := range testPercentiles {
+					if merged.percentiles[i] != serial.percentiles[i] {
+						t.Errorf("precise=%v %s %d chunks: p%v got %v, want %v", precise, tt.name, n, p,
+							merged.percentiles[i], serial.percentiles[i])
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package poi
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Code-Hex/exit"
+	"github.com/pkg/errors"
+)
+
+// Layouts of the time label
+const (
+	apacheLayout = "02/Jan/2006:15:04:05 -0700"
+	epochLayout  = "epoch"
+)
+
+var timeLayouts = map[string]string{
+	"rfc3339": time.RFC3339,
+	"apache":  apacheLayout,
+	"epoch":   epochLayout,
+}
+
+// Layouts tried when --time-format is not specified
+var autoLayouts = []string{
+	time.RFC3339,
+	apacheLayout,
+	"2006-01-02 15:04:05",
+	epochLayout,
+}
+
+// makeTimeRange parses --since and --until
+func (p *Poi) makeTimeRange() error {
+	if p.TimeFormat != "" {
+		layout, ok := timeLayouts[p.TimeFormat]
+		if !ok {
+			layout = p.TimeFormat // Go layout like "2006-01-02 15:04:05"
+		}
+		p.timeLayouts = []string{layout}
+	} else {
+		p.timeLayouts = autoLayouts
+	}
+
+	// html output draws requests over time, and --rate needs the span
+	p.useTime = p.Output == "html" || p.Rate
+
+	now := time.Now()
+	var err error
+	if p.Since != "" {
+		if p.since, err = p.parseTimeArg(p.Since, now); err != nil {
+			return exit.MakeDataErr(errors.Wrap(err, "invalid --since"))
+		}
+		p.needTime = true
+	}
+	if p.Until != "" {
+		if p.until, err = p.parseTimeArg(p.Until, now); err != nil {
+			return exit.MakeDataErr(errors.Wrap(err, "invalid --until"))
+		}
+		p.needTime = true
+	}
+	return nil
+}
+
+// parseTimeArg parses relative time like "-1h" from now, or absolute time.
+// Absolute time is RFC3339 or epoch whatever --time-format is, or in the
+// format of the time label.
+func (p *Poi) parseTimeArg(s string, now time.Time) (time.Time, error) {
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		if d, err := time.ParseDuration(s); err == nil {
+			return now.Add(d), nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := parseEpoch(s); err == nil {
+		return t, nil
+	}
+	return p.parseTime(s)
+}
+
+// parseTime parses the value of time label by --time-format
+func (p *Poi) parseTime(s string) (time.Time, error) {
+	for _, layout := range p.timeLayouts {
+		if layout == epochLayout {
+			if t, err := parseEpoch(s); err == nil {
+				return t, nil
+			}
+			continue
+		}
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("could not parse time %q", s)
+}
+
+// parseEpoch parses unix time in seconds or milliseconds with fraction
+func parseEpoch(s string) (time.Time, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if f > 1e11 {
+		f /= 1000 // milliseconds
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*1e9)), nil
+}
+
+// inTimeRange reports whether t is in the range of --since and --until
+func (p *Poi) inTimeRange(t time.Time) bool {
+	if !p.since.IsZero() && t.Before(p.since) {
+		return false
+	}
+	if !p.until.IsZero() && !t.Before(p.until) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+package poi
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Code-Hex/exit"
+	"github.com/pkg/errors"
+)
+
+// slidingWindow keeps results of each second in a ring buffer,
+// to aggregate only requests arrived in the recent --window.
+type slidingWindow struct {
+	size    int64   // seconds
+	buckets []*dict // results of each second, guarded by dataMu
+	secs    []int64 // unix time of each bucket
+	started time.Time
+
+	// mu is locked after dataMu, as the aggregator does in addWindow
+	mu      sync.Mutex
+	view    *dict // merged results of buckets
+	viewAt  time.Time
+	updated bool // whether buckets are updated after view is built
+}
+
+// Interval to rebuild the view of the window while lines are coming
+const windowRebuildInterval = 200 * time.Millisecond
+
+// makeWindow validates --window
+func (p *Poi) makeWindow() error {
+	if p.Window == 0 {
+		return nil
+	}
+	if !p.TailMode {
+		return exit.MakeDataErr(errors.New("--window needs --tail"))
+	}
+	// Reports and metrics are of all results, use --reset for an interval
+	if p.NoTUI {
+		return exit.MakeDataErr(errors.New("--window could not be used with --no-tui, use --reset instead"))
+	}
+	if p.Window < time.Second {
+		return exit.MakeDataErr(errors.Errorf("--window must be 1s or longer: %s", p.Window))
+	}
+	return nil
+}
+
+func newSlidingWindow(d time.Duration) *slidingWindow {
+	size := int64(d / time.Second)
+	return &slidingWindow{
+		size:    size,
+		buckets: make([]*dict, size),
+		secs:    make([]int64, size),
+	}
+}
+
+// add adds l into the bucket of now. dataMu must be locked.
+func (p *Poi) addWindow(w *slidingWindow, now int64, l *parsedLabel) {
+	if w.started.IsZero() {
+		w.started = time.Now()
+	}
+	i := now % w.size
+	if w.buckets[i] == nil || w.secs[i] != now {
+		w.buckets[i] = newDict() // Drop the old second
+		w.secs[i] = now
+	}
+	p.makeResult(w.buckets[i], l)
+	w.mu.Lock()
+	w.updated = true
+	w.mu.Unlock()
+}
+
+// result returns the results in the window until t. It is rebuilt
+// when the second goes by, or at intervals while lines are coming.
+func (w *slidingWindow) result(t time.Time, percentiles []float64) *dict {
+	dataMu.RLock()
+	defer dataMu.RUnlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := t.Unix()
+	if w.view != nil && w.viewAt.Unix() == now &&
+		(!w.updated || t.Sub(w.viewAt) < windowRebuildInterval) {
+		return w.view
+	}
+	w.updated = false
+
+	view := newDict()
+	for i, b := range w.buckets {
+		if b == nil || w.secs[i] <= now-w.size || w.secs[i] > now {
+			continue
+		}
+		for _, key := range b.keys {
+			val := b.m[key]
+			if dst := view.m[key]; dst != nil {
+				dst.merge(val)
+			} else {
+				view.set(key, val.clone())
+			}
+		}
+	}
+	// Rates are per the window, or since the start if it is shorter
+	view.window = time.Duration(w.size) * time.Second
+	if since := t.Sub(w.started); since < view.window {
+		view.window = since
+	}
+	view.finalize(percentiles)
+
+	// Keep the scroll position
+	if w.view != nil && w.view.start < len(view.keys) {
+		view.start = w.view.start
+	}
+	w.view, w.viewAt = view, t
+	return view
+}
+
+// topDict returns the results to show in the top pane
+func (p *Poi) topDict() *dict {
+	mu.RLock()
+	showWindow := p.showWindow
+	mu.RUnlock()
+	if p.window != nil && showWindow {
+		return p.window.result(time.Now(), p.calcPercentiles)
+	}
+	return dataMap
+}
+
+// toggleWindow switches the top pane between the window and since start
+func (p *Poi) toggleWindow() {
+	if p.window == nil {
+		return
+	}
+	mu.Lock()
+	p.showWindow = !p.showWindow
+	mu.Unlock()
+	p.renderTopPane()
+}
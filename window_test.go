@@ -0,0 +1,49 @@
+package poi
+
+import (
+	"testing"
+	"time"
+)
+
+// TestWindowConcurrent checks that the aggregator adding lines and the
+// top pane getting the results of the window do not deadlock
+func TestWindowConcurrent(t *testing.T) {
+	p := &Poi{}
+	w := newSlidingWindow(10 * time.Second)
+	l := &parsedLabel{keys: []string{"GET", "/"}, uri: "/", method: "GET", statusCode: "200", resTime: 0.1}
+
+	added, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(added)
+		for i := 0; i < 10000; i++ {
+			dataMu.Lock()
+			p.addWindow(w, time.Now().Unix(), l)
+			dataMu.Unlock()
+		}
+	}()
+	go func() {
+		defer close(done)
+		// The view is rebuilt every time by going the time ahead
+		at := time.Now()
+		for {
+			select {
+			case <-added:
+				return
+			default:
+				at = at.Add(windowRebuildInterval)
+				w.result(at, nil)
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlock")
+	}
+	w.view = nil // Rebuild with all lines
+	val := w.result(time.Now(), nil).get(makeKey(l.keys))
+	if val == nil || val.count != 10000 {
+		t.Errorf("got %v, want 10000 lines", val)
+	}
+}